@@ -0,0 +1,555 @@
+package main
+
+// The values the length counters get loaded with, indexed by the top five bits
+// written to a channel's fourth register.
+var lengthTable = [32]byte{
+  10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+  12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// The waveforms of the pulse channels' four duty cycles.
+var dutyTable = [4][8]byte{
+  {0, 1, 0, 0, 0, 0, 0, 0},
+  {0, 1, 1, 0, 0, 0, 0, 0},
+  {0, 1, 1, 1, 1, 0, 0, 0},
+  {1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// The triangle channel's waveform.
+var triangleTable = [32]byte{
+  15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+  0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// Bits of $4015.
+const (
+  APU_STATUS_PULSE1 byte = 1 << iota
+  APU_STATUS_PULSE2
+  APU_STATUS_TRIANGLE
+  APU_STATUS_NOISE
+  APU_STATUS_DMC
+  _
+  APU_STATUS_FRAME_IRQ
+  APU_STATUS_DMC_IRQ
+)
+
+// Counts down how long a channel has left to play, silencing it at 0.
+type lengthCounter struct {
+  enabled bool
+  halt bool
+  value byte
+}
+
+// Loads the counter with one of the lengths in the length table, as long as
+// the channel is enabled.
+func (l *lengthCounter) load(index byte) {
+  if l.enabled {
+    l.value = lengthTable[index & 0x1F]
+  }
+}
+
+// Enables or disables the channel, which clears the counter when disabled.
+func (l *lengthCounter) setEnabled(enabled bool) {
+  l.enabled = enabled
+  if !enabled {
+    l.value = 0
+  }
+}
+
+// Counts down, on half frames.
+func (l *lengthCounter) clock() {
+  if !l.halt && l.value > 0 {
+    l.value--
+  }
+}
+
+// Makes a volume that either holds steady or decays from 15 down to 0,
+// looping or not.
+type envelope struct {
+  start, loop, constant bool
+  period, divider, decay byte
+}
+
+// Sets the envelope up from the bottom six bits written to the channel's first
+// register.
+func (e *envelope) write(value byte) {
+  e.loop = value & 0x20 != 0
+  e.constant = value & 0x10 != 0
+  e.period = value & 0x0F
+}
+
+// Moves the envelope along, on quarter frames.
+func (e *envelope) clock() {
+  if e.start {
+    e.start = false
+    e.decay = 15
+    e.divider = e.period
+    return
+  }
+  if e.divider > 0 {
+    e.divider--
+    return
+  }
+  e.divider = e.period
+  if e.decay > 0 {
+    e.decay--
+  } else if e.loop {
+    e.decay = 15
+  }
+}
+
+// Gets the envelope's volume.
+func (e *envelope) volume() byte {
+  if e.constant {
+    return e.period
+  }
+  return e.decay
+}
+
+// One of the two square wave channels.
+type pulse struct {
+  // The first pulse channel negates sweep changes in one's complement, and the
+  // second in two's complement.
+  onesComplement bool
+
+  duty, dutyStep byte
+  // The period of the channel's timer, as written, and the CPU cycles left
+  // before it steps the waveform along.
+  period uint16
+  timer int
+
+  envelope envelope
+  length lengthCounter
+
+  sweepEnabled, sweepNegate, sweepReload bool
+  sweepPeriod, sweepDivider, sweepShift byte
+}
+
+// Writes one of the channel's four registers.
+func (p *pulse) write(register uint16, value byte) {
+  switch register {
+  case 0:
+    p.duty = value >> 6
+    p.length.halt = value & 0x20 != 0
+    p.envelope.write(value)
+  case 1:
+    p.sweepEnabled = value & 0x80 != 0
+    p.sweepPeriod = value >> 4 & 0x07
+    p.sweepNegate = value & 0x08 != 0
+    p.sweepShift = value & 0x07
+    p.sweepReload = true
+  case 2:
+    p.period = p.period & 0x0700 | uint16(value)
+  case 3:
+    p.period = p.period & 0x00FF | uint16(value & 0x07) << 8
+    p.length.load(value >> 3)
+    p.envelope.start = true
+    p.dutyStep = 0
+  }
+}
+
+// Gets the period the sweep unit is heading for.
+func (p *pulse) sweepTarget() uint16 {
+  change := p.period >> p.sweepShift
+  if !p.sweepNegate {
+    return p.period + change
+  }
+  if p.onesComplement {
+    change++
+  }
+  if change > p.period {
+    return 0
+  }
+  return p.period - change
+}
+
+// Gets whether or not the sweep unit is muting the channel, which it does when
+// the period is too low, or heading too high, whether or not it's enabled.
+func (p *pulse) sweepMuting() bool {
+  return p.period < 8 || (!p.sweepNegate && p.sweepTarget() > 0x07FF)
+}
+
+// Moves the sweep unit along, on half frames.
+func (p *pulse) clockSweep() {
+  if p.sweepDivider == 0 && p.sweepEnabled && p.sweepShift > 0 && !p.sweepMuting() {
+    p.period = p.sweepTarget()
+  }
+  if p.sweepDivider == 0 || p.sweepReload {
+    p.sweepDivider = p.sweepPeriod
+    p.sweepReload = false
+  } else {
+    p.sweepDivider--
+  }
+}
+
+// Runs the channel's timer for a CPU cycle. It's clocked every other cycle, so
+// the period in CPU cycles is twice that in APU cycles.
+func (p *pulse) clockTimer() {
+  if p.timer > 0 {
+    p.timer--
+    return
+  }
+  p.timer = (int(p.period) + 1) * 2 - 1
+  p.dutyStep = (p.dutyStep + 1) % 8
+}
+
+// Gets the channel's current output, from 0 to 15.
+func (p *pulse) output() byte {
+  if p.length.value == 0 || p.sweepMuting() || dutyTable[p.duty][p.dutyStep] == 0 {
+    return 0
+  }
+  return p.envelope.volume()
+}
+
+// The triangle wave channel.
+type triangle struct {
+  period uint16
+  timer int
+  step byte
+
+  length lengthCounter
+  // The linear counter, which gives finer control over how long the channel
+  // plays than the length counter. Its control flag is the length counter's
+  // halt flag.
+  linearReload, linearCounter byte
+  linearReloadFlag bool
+}
+
+// Writes one of the channel's registers.
+func (t *triangle) write(register uint16, value byte) {
+  switch register {
+  case 0:
+    t.length.halt = value & 0x80 != 0
+    t.linearReload = value & 0x7F
+  case 2:
+    t.period = t.period & 0x0700 | uint16(value)
+  case 3:
+    t.period = t.period & 0x00FF | uint16(value & 0x07) << 8
+    t.length.load(value >> 3)
+    t.linearReloadFlag = true
+  }
+}
+
+// Moves the linear counter along, on quarter frames.
+func (t *triangle) clockLinearCounter() {
+  if t.linearReloadFlag {
+    t.linearCounter = t.linearReload
+  } else if t.linearCounter > 0 {
+    t.linearCounter--
+  }
+  if !t.length.halt {
+    t.linearReloadFlag = false
+  }
+}
+
+// Runs the channel's timer for a CPU cycle. The waveform only moves along
+// while both counters are non-zero, so it holds its level when silenced.
+func (t *triangle) clockTimer() {
+  if t.timer > 0 {
+    t.timer--
+    return
+  }
+  t.timer = int(t.period)
+  if t.length.value > 0 && t.linearCounter > 0 {
+    t.step = (t.step + 1) % 32
+  }
+}
+
+// Gets the channel's current output, from 0 to 15.
+func (t *triangle) output() byte {
+  return triangleTable[t.step]
+}
+
+// The pseudo-random noise channel.
+type noise struct {
+  // The noise periods, in CPU cycles, for the console's region.
+  periods *[16]uint16
+
+  // Whether the shift register runs in short mode, which gives a metallic
+  // tone, and the shift register itself.
+  mode bool
+  shift uint16
+  period uint16
+  timer int
+
+  envelope envelope
+  length lengthCounter
+}
+
+// Writes one of the channel's registers.
+func (n *noise) write(register uint16, value byte) {
+  switch register {
+  case 0:
+    n.length.halt = value & 0x20 != 0
+    n.envelope.write(value)
+  case 2:
+    n.mode = value & 0x80 != 0
+    n.period = n.periods[value & 0x0F]
+  case 3:
+    n.length.load(value >> 3)
+    n.envelope.start = true
+  }
+}
+
+// Runs the channel's timer for a CPU cycle, clocking the shift register every
+// time it runs out.
+func (n *noise) clockTimer() {
+  if n.timer > 0 {
+    n.timer--
+    return
+  }
+  n.timer = int(n.period) - 1
+
+  tap := uint(1)
+  if n.mode {
+    tap = 6
+  }
+  feedback := (n.shift ^ n.shift >> tap) & 1
+  n.shift = n.shift >> 1 | feedback << 14
+}
+
+// Gets the channel's current output, from 0 to 15.
+func (n *noise) output() byte {
+  if n.length.value == 0 || n.shift & 1 != 0 {
+    return 0
+  }
+  return n.envelope.volume()
+}
+
+// A sound chip on a cartridge, whose output gets mixed in with the APU's.
+type ExpansionAudio interface {
+  // Gets the chip's output, on the same scale as the APU's.
+  Output() float64
+}
+
+// Represents the 2A03's APU, at $4000 to $4017.
+type APU struct {
+  region *Region
+  // The CPU it's attached to, if it is, whose data bus is left on the bit of
+  // $4015 the APU doesn't drive.
+  cpu *CPU
+
+  pulse1, pulse2 pulse
+  triangle triangle
+  noise noise
+  dmc dmc
+  // Sound chips on the cartridge, if there are any.
+  expansion ExpansionAudio
+
+  // The number of CPU cycles the APU has been clocked for.
+  cycles uint64
+
+  // The frame counter: whether it's in 5-step mode, whether its IRQ is
+  // inhibited or raised, and how many CPU cycles it is into its sequence.
+  fiveStep, irqInhibit, frameIRQ bool
+  frameCycle int
+  // A write to $4017 takes a few cycles to reset the sequence. These are the
+  // cycles left before it does, and the value written.
+  frameResetDelay int
+  frameResetValue byte
+}
+
+// Initializes a new APU, with NTSC timing.
+func APUNew() *APU {
+  a := &APU{}
+  a.pulse1.onesComplement = true
+  a.noise.shift = 1
+  a.dmc.bitsRemaining = 8
+  a.dmc.silence = true
+  a.SetRegion(RegionNTSC)
+  return a
+}
+
+// Sets the console's timing, which decides the noise and DMC channels'
+// periods.
+func (a *APU) SetRegion(region *Region) {
+  a.region = region
+  a.noise.periods = &region.NoisePeriods
+  a.noise.period = a.noise.periods[0]
+  a.dmc.periods = &region.DMCPeriods
+  a.dmc.period = a.dmc.periods[0]
+}
+
+// Maps the APU's registers onto the CPU bus, and lets it fetch DMC samples and
+// raise IRQs.
+//
+// The other registers are write-only, so reads of them give back open bus,
+// and reads of $4017 are left to the controllers. Clocking it is left to the
+// Scheduler, which should do so every region.CPUDivider master clock cycles.
+func (a *APU) Attach(cpu *CPU) {
+  cpu.MapRead(0x4000, 0x4013, openBus{cpu})
+  cpu.MapWrite(0x4000, 0x4013, a)
+  cpu.Map(0x4015, 0x4015, a)
+  cpu.MapWrite(0x4017, 0x4017, a)
+  cpu.ConnectIRQ(a)
+  a.cpu = cpu
+  a.dmc.cpu = cpu
+}
+
+// Mixes a cartridge's sound chip in with the APU's output.
+func (a *APU) SetExpansion(expansion ExpansionAudio) {
+  a.expansion = expansion
+}
+
+// Resets the APU, the way the reset button does: every channel gets silenced,
+// and the frame counter starts its sequence over in the mode it was in.
+func (a *APU) Reset() {
+  a.Write(0x4015, 0)
+  var frame byte
+  if a.fiveStep {
+    frame |= 0x80
+  }
+  if a.irqInhibit {
+    frame |= 0x40
+  }
+  a.Write(0x4017, frame)
+  a.triangle.step = 0
+  a.dmc.level &= 1
+}
+
+// Gets whether or not the APU is asserting the CPU's IRQ line.
+func (a *APU) IRQ() bool {
+  return a.dmc.irq || a.frameIRQ
+}
+
+// Advances the APU by a CPU cycle.
+func (a *APU) Clock() {
+  a.cycles++
+  a.clockFrameCounter()
+  a.pulse1.clockTimer()
+  a.pulse2.clockTimer()
+  a.triangle.clockTimer()
+  a.noise.clockTimer()
+  a.dmc.clockTimer()
+}
+
+// Runs the frame counter for a CPU cycle, clocking quarter and half frames,
+// and raising the frame IRQ at the end of the 4-step sequence.
+func (a *APU) clockFrameCounter() {
+  if a.frameResetDelay > 0 {
+    a.frameResetDelay--
+    if a.frameResetDelay == 0 {
+      a.fiveStep = a.frameResetValue & 0x80 != 0
+      a.frameCycle = 0
+      // Switching to 5-step mode clocks a half frame straight away.
+      if a.fiveStep {
+        a.quarterFrame()
+        a.halfFrame()
+      }
+      // The sequence starts over on this cycle, the same as it does when it
+      // wraps, so the first step is as far away as it is then.
+      return
+    }
+  }
+
+  a.frameCycle++
+  steps := &a.region.FourStepCycles
+  if a.fiveStep {
+    steps = &a.region.FiveStepCycles
+  }
+  switch a.frameCycle {
+  case steps[0], steps[2]:
+    a.quarterFrame()
+  case steps[1]:
+    a.quarterFrame()
+    a.halfFrame()
+  case steps[3]:
+    if !a.fiveStep {
+      a.raiseFrameIRQ()
+    }
+  case steps[4]:
+    a.quarterFrame()
+    a.halfFrame()
+    if !a.fiveStep {
+      a.raiseFrameIRQ()
+    }
+  case steps[5]:
+    if !a.fiveStep {
+      a.raiseFrameIRQ()
+    }
+    a.frameCycle = 0
+  }
+}
+
+// Raises the frame IRQ, unless it's inhibited.
+func (a *APU) raiseFrameIRQ() {
+  if !a.irqInhibit {
+    a.frameIRQ = true
+  }
+}
+
+// Moves the envelopes and the triangle's linear counter along.
+func (a *APU) quarterFrame() {
+  a.pulse1.envelope.clock()
+  a.pulse2.envelope.clock()
+  a.triangle.clockLinearCounter()
+  a.noise.envelope.clock()
+}
+
+// Moves the length counters and sweep units along.
+func (a *APU) halfFrame() {
+  a.pulse1.length.clock()
+  a.pulse2.length.clock()
+  a.triangle.length.clock()
+  a.noise.length.clock()
+  a.pulse1.clockSweep()
+  a.pulse2.clockSweep()
+}
+
+// Reads $4015, which has which channels still have length left to play, and
+// whether the frame counter or DMC have raised an IRQ. Reading it acknowledges
+// the frame IRQ. Bit 5 isn't driven, so it's whatever was last on the data
+// bus. The other registers are write-only.
+func (a *APU) Read(address uint16) byte {
+  if address != 0x4015 {
+    return 0
+  }
+  var value byte
+  if a.cpu != nil {
+    value = a.cpu.DataBus() & 0x20
+  }
+  if a.pulse1.length.value > 0 { value |= APU_STATUS_PULSE1 }
+  if a.pulse2.length.value > 0 { value |= APU_STATUS_PULSE2 }
+  if a.triangle.length.value > 0 { value |= APU_STATUS_TRIANGLE }
+  if a.noise.length.value > 0 { value |= APU_STATUS_NOISE }
+  if a.dmc.remaining > 0 { value |= APU_STATUS_DMC }
+  if a.frameIRQ { value |= APU_STATUS_FRAME_IRQ }
+  if a.dmc.irq { value |= APU_STATUS_DMC_IRQ }
+  a.frameIRQ = false
+  return value
+}
+
+// Writes one of the APU's registers.
+func (a *APU) Write(address uint16, value byte) {
+  switch {
+  case address >= 0x4000 && address <= 0x4003:
+    a.pulse1.write(address - 0x4000, value)
+  case address >= 0x4004 && address <= 0x4007:
+    a.pulse2.write(address - 0x4004, value)
+  case address >= 0x4008 && address <= 0x400B:
+    a.triangle.write(address - 0x4008, value)
+  case address >= 0x400C && address <= 0x400F:
+    a.noise.write(address - 0x400C, value)
+  case address >= 0x4010 && address <= 0x4013:
+    a.dmc.write(address - 0x4010, value)
+  case address == 0x4015:
+    a.pulse1.length.setEnabled(value & APU_STATUS_PULSE1 != 0)
+    a.pulse2.length.setEnabled(value & APU_STATUS_PULSE2 != 0)
+    a.triangle.length.setEnabled(value & APU_STATUS_TRIANGLE != 0)
+    a.noise.length.setEnabled(value & APU_STATUS_NOISE != 0)
+    a.dmc.setEnabled(value & APU_STATUS_DMC != 0)
+  case address == 0x4017:
+    // Setting the inhibit flag acknowledges the IRQ straight away, but the
+    // sequence only resets 3 or 4 cycles later, depending on whether the
+    // write lands on an APU cycle or between two.
+    a.irqInhibit = value & 0x40 != 0
+    if a.irqInhibit {
+      a.frameIRQ = false
+    }
+    a.frameResetValue = value
+    a.frameResetDelay = 3
+    if a.cycles % 2 == 1 {
+      a.frameResetDelay = 4
+    }
+  }
+}
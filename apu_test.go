@@ -0,0 +1,579 @@
+package main
+
+import "testing"
+import "log"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+func TestAPULengthCounters(t *testing.T) {
+  apu := APUNew()
+
+  // Length counters don't load while their channel is disabled.
+  apu.Write(0x4003, 0x08)
+  if apu.Read(0x4015) != 0 {
+    log.Printf("Expecting no length to be loaded into a disabled channel")
+    t.Fail()
+  }
+
+  apu.Write(0x4015, 0x0F)
+  apu.Write(0x4003, 0x08)
+  apu.Write(0x400B, 0x08)
+  if apu.Read(0x4015) != APU_STATUS_PULSE1 | APU_STATUS_TRIANGLE {
+    log.Printf("Expecting pulse 1 and the triangle to be playing, but got %X", apu.Read(0x4015))
+    t.Fail()
+  }
+  if apu.pulse1.length.value != 254 {
+    log.Printf("Expecting a length of 254, but got %d", apu.pulse1.length.value)
+    t.Fail()
+  }
+
+  // Half frames count it down, unless it's halted.
+  apu.halfFrame()
+  if apu.pulse1.length.value != 253 {
+    log.Printf("Expecting a length of 253, but got %d", apu.pulse1.length.value)
+    t.Fail()
+  }
+  apu.Write(0x4000, 0x20)
+  apu.halfFrame()
+  if apu.pulse1.length.value != 253 {
+    log.Printf("Expecting a halted length counter to hold, but got %d", apu.pulse1.length.value)
+    t.Fail()
+  }
+
+  // Disabling a channel clears its length.
+  apu.Write(0x4015, 0x00)
+  if apu.Read(0x4015) != 0 {
+    log.Printf("Expecting disabling the channels to silence them")
+    t.Fail()
+  }
+}
+
+func TestAPUPulse(t *testing.T) {
+  apu := APUNew()
+  apu.Write(0x4015, 0x01)
+
+  // A constant volume of 9 with the 50% duty cycle.
+  apu.Write(0x4000, 0xB9)
+  apu.Write(0x4002, 0x10)
+  apu.Write(0x4003, 0x08)
+  var levels [2]int
+  for i := 0; i < (0x10 + 1) * 2 * 8; i++ {
+    apu.Clock()
+    if apu.pulse1.output() == 9 {
+      levels[1]++
+    } else {
+      levels[0]++
+    }
+  }
+  if levels[0] != levels[1] {
+    log.Printf("Expecting a 50%% duty cycle, but got %v", levels)
+    t.Fail()
+  }
+
+  // Periods under 8 are muted by the sweep unit.
+  apu.Write(0x4002, 0x07)
+  apu.Write(0x4003, 0x08)
+  if !apu.pulse1.sweepMuting() {
+    log.Printf("Expecting a period of 7 to be muted")
+    t.Fail()
+  }
+
+  // The sweep unit on pulse 1 negates in one's complement, and pulse 2 in
+  // two's complement.
+  apu.Write(0x4002, 0x00)
+  apu.Write(0x4003, 0x09)
+  apu.Write(0x4001, 0x89)
+  apu.Write(0x4006, 0x00)
+  apu.Write(0x4007, 0x09)
+  apu.Write(0x4005, 0x89)
+  if apu.pulse1.sweepTarget() != 0x7F || apu.pulse2.sweepTarget() != 0x80 {
+    log.Printf("Expecting sweep targets 7F and 80, but got %X and %X", apu.pulse1.sweepTarget(), apu.pulse2.sweepTarget())
+    t.Fail()
+  }
+  apu.halfFrame()
+  if apu.pulse1.period != 0x7F {
+    log.Printf("Expecting the sweep to change the period to 7F, but got %X", apu.pulse1.period)
+    t.Fail()
+  }
+}
+
+func TestAPUEnvelope(t *testing.T) {
+  apu := APUNew()
+  apu.Write(0x4015, 0x08)
+  apu.Write(0x400C, 0x01)
+  apu.Write(0x400F, 0x08)
+
+  // The envelope starts at 15 and decays every other quarter frame.
+  apu.quarterFrame()
+  if apu.noise.envelope.volume() != 15 {
+    log.Printf("Expecting the envelope to start at 15, but got %d", apu.noise.envelope.volume())
+    t.Fail()
+  }
+  for i := 0; i < 4; i++ {
+    apu.quarterFrame()
+  }
+  if apu.noise.envelope.volume() != 13 {
+    log.Printf("Expecting the envelope to be at 13, but got %d", apu.noise.envelope.volume())
+    t.Fail()
+  }
+}
+
+func TestAPUNoise(t *testing.T) {
+  // In long mode the shift register repeats every 32767 steps, and in short
+  // mode every 93.
+  for _, test := range []struct{ mode byte; period int }{{0x00, 32767}, {0x80, 93}} {
+    apu := APUNew()
+    apu.Write(0x400E, test.mode)
+    start := apu.noise.shift
+    steps := 0
+    for {
+      for i := 0; i < int(apu.noise.period); i++ {
+        apu.Clock()
+      }
+      steps++
+      if apu.noise.shift == start || steps > 40000 {
+        break
+      }
+    }
+    if steps != test.period {
+      log.Printf("Expecting the shift register to repeat after %d steps, but took %d", test.period, steps)
+      t.Fail()
+    }
+  }
+}
+
+func TestAPUTriangle(t *testing.T) {
+  apu := APUNew()
+  apu.Write(0x4015, 0x04)
+  apu.Write(0x4008, 0x05)
+  apu.Write(0x400A, 0x00)
+  apu.Write(0x400B, 0x08)
+
+  // Nothing moves until the linear counter has been reloaded.
+  apu.Clock()
+  apu.Clock()
+  if apu.triangle.step != 0 {
+    log.Printf("Expecting the triangle to hold with the linear counter at 0")
+    t.Fail()
+  }
+  apu.quarterFrame()
+  if apu.triangle.linearCounter != 5 {
+    log.Printf("Expecting the linear counter to be reloaded with 5, but got %d", apu.triangle.linearCounter)
+    t.Fail()
+  }
+  apu.Clock()
+  if apu.triangle.step != 1 {
+    log.Printf("Expecting the triangle to step every cycle with a period of 0")
+    t.Fail()
+  }
+
+  // With the control flag clear, the reload only happens once.
+  for i := 0; i < 5; i++ {
+    apu.quarterFrame()
+  }
+  if apu.triangle.linearCounter != 0 {
+    log.Printf("Expecting the linear counter to count down to 0, but got %d", apu.triangle.linearCounter)
+    t.Fail()
+  }
+}
+
+func TestAPUDMC(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xEA, // NOP
+    0xEA, // NOP
+  })
+  apu := APUNew()
+  apu.Attach(cpu)
+  scheduleAPU(cpu, apu)
+  // IRQs are disabled at power on.
+  cpu.SetI(false)
+  cpu.memory.SetUint8At(0xC000, 0xFF)
+  cpu.memory.SetUint8At(0xFFFE, 0x00)
+  cpu.memory.SetUint8At(0xFFFF, 0x90)
+
+  // A single byte sample at $C000, at the fastest rate, with the IRQ enabled.
+  cpu.write(0x4010, 0x8F)
+  cpu.write(0x4011, 0x40)
+  cpu.write(0x4012, 0x00)
+  cpu.write(0x4013, 0x00)
+  cpu.write(0x4015, 0x10)
+  if apu.Read(0x4015) & APU_STATUS_DMC == 0 {
+    log.Printf("Expecting the DMC to be playing")
+    t.Fail()
+  }
+
+  // The fetch is halted on the write to $4015, so it takes 3 cycles.
+  apu.Clock()
+  if !apu.dmc.bufferFull || apu.dmc.buffer != 0xFF {
+    log.Printf("Expecting the sample buffer to be filled")
+    t.Fail()
+  }
+  if cpu.stall != 3 {
+    log.Printf("Expecting the fetch to stall the CPU for 3 cycles, but got %d", cpu.stall)
+    t.Fail()
+  }
+  // Bit 5 is open bus, which the fetch left at $FF.
+  if apu.Read(0x4015) != APU_STATUS_DMC_IRQ | 0x20 || !apu.IRQ() {
+    log.Printf("Expecting the sample to be over and the IRQ raised, but got %X", apu.Read(0x4015))
+    t.Fail()
+  }
+
+  // The IRQ gets serviced, through the vector at $FFFE.
+  cpu.stall = 0
+  cpu.Step()
+  if cpu.pc != 0x9000 {
+    log.Printf("Expecting the IRQ handler at $9000, but got %X", cpu.pc)
+    t.Fail()
+  }
+
+  // All 1s move the level up by 2 for every bit.
+  for i := 0; i < 54 * 20; i++ {
+    apu.Clock()
+  }
+  if apu.dmc.output() != 0x40 + 16 {
+    log.Printf("Expecting the level to be %X, but got %X", 0x40 + 16, apu.dmc.output())
+    t.Fail()
+  }
+
+  // Writing $4015 acknowledges the IRQ.
+  apu.Write(0x4015, 0x00)
+  if apu.IRQ() {
+    log.Printf("Expecting the IRQ to be acknowledged")
+    t.Fail()
+  }
+}
+
+func TestDMCFetchStalls(t *testing.T) {
+  cpu := CPUNew()
+
+  cpu.read(0x0000)
+  cpu.DMCFetch(0xC000)
+  if cpu.stall != 4 {
+    log.Printf("Expecting a fetch halted on a read to take 4 cycles, but got %d", cpu.stall)
+    t.Fail()
+  }
+
+  cpu.stall = 0
+  cpu.stalling = true
+  cpu.DMCFetch(0xC000)
+  if cpu.stall != 2 {
+    log.Printf("Expecting a fetch during OAM DMA to take 2 cycles, but got %d", cpu.stall)
+    t.Fail()
+  }
+
+  // A fetch halted on a controller read has the read repeated.
+  reads := 0
+  cpu.MapRead(0x4016, 0x4016, readerFunc(func(address uint16) byte {
+    reads++
+    return 0
+  }))
+  cpu.stall = 0
+  cpu.stalling = false
+  cpu.read(0x4016)
+  cpu.DMCFetch(0xC000)
+  if reads != 2 {
+    log.Printf("Expecting the controller to be read twice, but got %d", reads)
+    t.Fail()
+  }
+}
+
+// Lets a function stand in for a device on the bus.
+// Has the APU clocked along with the CPU, off a scheduler.
+func scheduleAPU(cpu *CPU, apu *APU) {
+  scheduler := SchedulerNew(apu.region)
+  scheduler.Add(apu.region.CPUDivider, apu.Clock)
+  cpu.Connect(scheduler)
+}
+
+type readerFunc func(address uint16) byte
+
+func (f readerFunc) Read(address uint16) byte { return f(address) }
+
+func TestAPUFrameCounter(t *testing.T) {
+  apu := APUNew()
+
+  // 4-step mode raises the IRQ at the end of every sequence.
+  for i := 0; i < 29828; i++ {
+    apu.Clock()
+  }
+  if !apu.IRQ() || apu.Read(0x4015) & APU_STATUS_FRAME_IRQ == 0 {
+    log.Printf("Expecting the frame IRQ to be raised")
+    t.Fail()
+  }
+  if apu.IRQ() {
+    log.Printf("Expecting reading $4015 to acknowledge the frame IRQ")
+    t.Fail()
+  }
+
+  // Setting the inhibit flag stops it from being raised.
+  apu.Write(0x4017, 0x40)
+  for i := 0; i < 30000; i++ {
+    apu.Clock()
+  }
+  if apu.IRQ() {
+    log.Printf("Expecting the frame IRQ to be inhibited")
+    t.Fail()
+  }
+
+  // Switching to 5-step mode clocks a half frame once the write takes effect,
+  // which is 3 cycles later on an even cycle and 4 on an odd one.
+  apu = APUNew()
+  apu.Write(0x4015, 0x01)
+  apu.Write(0x4003, 0x08)
+  apu.Write(0x4017, 0x80)
+  for i := 0; i < 2; i++ {
+    apu.Clock()
+  }
+  if apu.pulse1.length.value != 254 {
+    log.Printf("Expecting the write to $4017 not to have taken effect yet")
+    t.Fail()
+  }
+  apu.Clock()
+  if apu.pulse1.length.value != 253 {
+    log.Printf("Expecting a half frame 3 cycles after the write, but the length is %d", apu.pulse1.length.value)
+    t.Fail()
+  }
+
+  apu.Write(0x4017, 0x80)
+  for i := 0; i < 3; i++ {
+    apu.Clock()
+  }
+  if apu.pulse1.length.value != 253 {
+    log.Printf("Expecting a write on an odd cycle to take 4 cycles")
+    t.Fail()
+  }
+  apu.Clock()
+  if apu.pulse1.length.value != 252 {
+    log.Printf("Expecting a half frame 4 cycles after the write, but the length is %d", apu.pulse1.length.value)
+    t.Fail()
+  }
+
+  // 5-step mode never raises the IRQ, and clocks half frames twice a sequence.
+  for i := 0; i < 37282; i++ {
+    apu.Clock()
+  }
+  if apu.IRQ() {
+    log.Printf("Expecting no frame IRQ in 5-step mode")
+    t.Fail()
+  }
+  if apu.pulse1.length.value != 250 {
+    log.Printf("Expecting two half frames in a 5-step sequence, but the length is %d", apu.pulse1.length.value)
+    t.Fail()
+  }
+
+  // The first quarter frame comes as long after the write takes effect as it
+  // does after the sequence wraps.
+  apu = APUNew()
+  apu.Write(0x4017, 0x00)
+  for apu.frameResetDelay > 0 {
+    apu.Clock()
+  }
+  apu.pulse1.envelope.start = true
+  cycles := 0
+  for apu.pulse1.envelope.start {
+    apu.Clock()
+    cycles++
+  }
+  if cycles != apu.region.FourStepCycles[0] {
+    log.Printf("Expecting the first quarter frame %d cycles after the reset, but got %d", apu.region.FourStepCycles[0], cycles)
+    t.Fail()
+  }
+  for i := 0; i < apu.region.FourStepCycles[5] - cycles; i++ {
+    apu.Clock()
+  }
+  apu.pulse1.envelope.start = true
+  cycles = 0
+  for apu.pulse1.envelope.start {
+    apu.Clock()
+    cycles++
+  }
+  if cycles != apu.region.FourStepCycles[0] {
+    log.Printf("Expecting the first quarter frame %d cycles after the wrap, but got %d", apu.region.FourStepCycles[0], cycles)
+    t.Fail()
+  }
+}
+
+func TestAPUOpenBus(t *testing.T) {
+  cpu := CPUNew()
+  apu := APUNew()
+  apu.Attach(cpu)
+  cpu.memory.SetUint8At(0x0010, 0xFF)
+
+  cpu.read(0x0010)
+  if value := cpu.read(0x4000); value != 0xFF {
+    log.Printf("Expecting the write-only registers to read back open bus, but got %X", value)
+    t.Fail()
+  }
+  if value := cpu.read(0x4015); value != 0x20 {
+    log.Printf("Expecting bit 5 of $4015 to be open bus, but got %X", value)
+    t.Fail()
+  }
+  cpu.write(0x4015, 0x01)
+  cpu.write(0x4003, 0x08)
+  if value := cpu.read(0x4015); value != APU_STATUS_PULSE1 {
+    log.Printf("Expecting only pulse 1 to be playing, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestAPUFrameIRQOnCPU(t *testing.T) {
+  program := make([]byte, 0x100)
+  for i := range program {
+    program[i] = 0xEA // NOP
+  }
+  cpu := initCPUWithBasicInstructions(program)
+  apu := APUNew()
+  apu.Attach(cpu)
+  scheduleAPU(cpu, apu)
+  cpu.SetI(false)
+  cpu.memory.SetUint8At(0xFFFE, 0x00)
+  cpu.memory.SetUint8At(0xFFFF, 0x90)
+  for i := 0x9000; i < 0x9100; i++ {
+    cpu.memory.SetUint8At(uint16(i), 0xEA)
+  }
+
+  for cpu.pc < 0x9000 && cpu.Cycles() < 40000 {
+    cpu.Step()
+    if cpu.pc >= 0x80F0 && cpu.pc < 0x9000 {
+      cpu.pc = 0x8000
+    }
+  }
+  if cpu.pc < 0x9000 || cpu.Cycles() < 29828 {
+    log.Printf("Expecting the frame IRQ to get serviced, at cycle %d", cpu.Cycles())
+    t.Fail()
+  }
+}
+
+func TestMixer(t *testing.T) {
+  apu := APUNew()
+  mixer := MixerNew(apu, 44100, SAMPLE_INT16)
+
+  // A second of a constant volume square wave at around 440 Hz.
+  apu.Write(0x4015, 0x01)
+  apu.Write(0x4000, 0xBF)
+  apu.Write(0x4002, 0xFD)
+  apu.Write(0x4003, 0x00)
+  apu.pulse1.length.halt = true
+  apu.pulse1.length.value = 10
+  for i := 0; i < 1789773; i++ {
+    apu.Clock()
+    mixer.Clock()
+  }
+  if frames := mixer.Buffered(); frames < 44090 || frames > 44110 {
+    log.Printf("Expecting about 44100 frames, but got %d", frames)
+    t.Fail()
+  }
+
+  buffer := make([]byte, 4 * 1000)
+  n, err := mixer.Read(buffer)
+  if n != len(buffer) || err != nil {
+    log.Printf("Expecting a full read, but got %d (%v)", n, err)
+    t.Fail()
+  }
+
+  // The high-pass filters centre the wave on 0.
+  var lowest, highest int16
+  for i := 0; i < n; i += 4 {
+    left := int16(uint16(buffer[i]) | uint16(buffer[i + 1]) << 8)
+    right := int16(uint16(buffer[i + 2]) | uint16(buffer[i + 3]) << 8)
+    if left != right {
+      log.Printf("Expecting both channels to be the same")
+      t.FailNow()
+    }
+    if left < lowest { lowest = left }
+    if left > highest { highest = left }
+  }
+  if lowest > -2000 || highest < 2000 {
+    log.Printf("Expecting a wave around 0, but it goes from %d to %d", lowest, highest)
+    t.Fail()
+  }
+
+  // Once it runs out of samples, it reads as silence.
+  mixer.Read(make([]byte, 4 * 50000))
+  buffer = make([]byte, 8)
+  buffer[0] = 0xFF
+  mixer.Read(buffer)
+  if buffer[0] != 0 {
+    log.Printf("Expecting silence once the samples run out")
+    t.Fail()
+  }
+
+  // A buffer too small for a frame is an error rather than an empty read.
+  if n, err := mixer.Read(make([]byte, 3)); n != 0 || err != io.ErrShortBuffer {
+    log.Printf("Expecting a short buffer error, but got %d (%v)", n, err)
+    t.Fail()
+  }
+}
+
+func TestRecorder(t *testing.T) {
+  dir, err := ioutil.TempDir("", "gones")
+  if err != nil {
+    log.Printf("Couldn't make a temporary directory: %s", err)
+    t.FailNow()
+  }
+  defer os.RemoveAll(dir)
+
+  apu := APUNew()
+  apu.Write(0x4015, 0x04)
+  apu.Write(0x4008, 0xFF)
+  apu.Write(0x400A, 0x40)
+  apu.Write(0x400B, 0x08)
+  apu.quarterFrame()
+
+  filename := filepath.Join(dir, "song.wav")
+  recorder, err := RecorderNew(apu, filename, 48000, true)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  for i := 0; i < 1789773 / 10; i++ {
+    apu.Clock()
+    recorder.Clock()
+  }
+  if err := recorder.Close(); err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.Fail()
+  }
+
+  for _, name := range []string{"song.wav", "song-triangle.wav", "song-expansion.wav"} {
+    data, err := ioutil.ReadFile(filepath.Join(dir, name))
+    if err != nil {
+      log.Printf("Expecting %s to have been written (%s)", name, err)
+      t.Fail()
+      continue
+    }
+    if string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+      log.Printf("Expecting %s to be a WAV file", name)
+      t.Fail()
+    }
+    if rate := GetUint32LEAt(data, 24); rate != 48000 {
+      log.Printf("Expecting a sample rate of 48000, but got %d", rate)
+      t.Fail()
+    }
+    size := int(GetUint32LEAt(data, 40))
+    if size != len(data) - WAV_HEADER_SIZE || size / 2 < 4790 || size / 2 > 4810 {
+      log.Printf("Expecting about 4800 samples in %s, but got %d", name, size / 2)
+      t.Fail()
+    }
+  }
+
+  // The triangle's stem has the triangle in it, and the expansion's is silent.
+  triangle, _ := ioutil.ReadFile(filepath.Join(dir, "song-triangle.wav"))
+  expansion, _ := ioutil.ReadFile(filepath.Join(dir, "song-expansion.wav"))
+  loud := false
+  for i := WAV_HEADER_SIZE; i + 1 < len(triangle); i += 2 {
+    if triangle[i + 1] != 0 && triangle[i + 1] != 0xFF {
+      loud = true
+    }
+    if expansion[i] != 0 || expansion[i + 1] != 0 {
+      log.Printf("Expecting the expansion audio stem to be silent")
+      t.FailNow()
+    }
+  }
+  if !loud {
+    log.Printf("Expecting the triangle's stem to have the triangle in it")
+    t.Fail()
+  }
+}
@@ -0,0 +1,111 @@
+package main
+
+import "archive/zip"
+import "bytes"
+import "compress/gzip"
+import "errors"
+import "io/ioutil"
+import "path"
+import "strings"
+
+// File extensions of the ROM images looked for inside of archives.
+var romExtensions = []string{".nes", ".unf", ".unif"}
+
+func isZip(data []byte) bool {
+  return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+func isGzip(data []byte) bool {
+  return bytes.HasPrefix(data, []byte{0x1F, 0x8B})
+}
+
+func isROMName(name string) bool {
+  extension := strings.ToLower(path.Ext(name))
+  for _, e := range romExtensions {
+    if extension == e {
+      return true
+    }
+  }
+  return false
+}
+
+// Lists the ROM images held in a zip or gzip archive.
+//
+// A gzip archive only ever holds one file, whose name may not have been
+// recorded, in which case its entry is the empty string.
+func ArchiveEntries(data []byte) ([]string, error) {
+  switch {
+  case isZip(data):
+    archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil { return nil, err }
+    entries := []string{}
+    for _, f := range archive.File {
+      if isROMName(f.Name) {
+        entries = append(entries, f.Name)
+      }
+    }
+    return entries, nil
+  case isGzip(data):
+    archive, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil { return nil, err }
+    defer archive.Close()
+    return []string{archive.Name}, nil
+  }
+  return nil, errors.New("Not a zip or gzip archive")
+}
+
+// Extracts a ROM image from a zip or gzip archive.
+//
+// The entry picks which ROM image to extract when a zip archive holds several;
+// it can be left empty when there's only one. Data that isn't an archive at
+// all is handed back as is, so raw ROM images can go through here too.
+func ExtractROM(data []byte, entry string) ([]byte, error) {
+  switch {
+  case isZip(data):
+    archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil { return nil, err }
+
+    var found *zip.File
+    for _, f := range archive.File {
+      if !isROMName(f.Name) {
+        continue
+      }
+      if entry == "" {
+        if found != nil {
+          return nil, errors.New("Archive holds several ROMs; an entry must be picked")
+        }
+        found = f
+      } else if f.Name == entry {
+        found = f
+        break
+      }
+    }
+    if found == nil {
+      return nil, errors.New("ROM not found in archive")
+    }
+
+    reader, err := found.Open()
+    if err != nil { return nil, err }
+    defer reader.Close()
+    return ioutil.ReadAll(reader)
+  case isGzip(data):
+    archive, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil { return nil, err }
+    defer archive.Close()
+    if entry != "" && archive.Name != "" && archive.Name != entry {
+      return nil, errors.New("ROM not found in archive")
+    }
+    return ioutil.ReadAll(archive)
+  }
+  return data, nil
+}
+
+// Loads a cartridge from a ROM file on disk, unpacking it first if it's a zip
+// or gzip archive, then applying any patches given.
+func LoadCartridgeFile(filename string, entry string, patches ...[]byte) (*Cartridge, error) {
+  data, err := ioutil.ReadFile(filename)
+  if err != nil { return nil, err }
+  rom, err := ExtractROM(data, entry)
+  if err != nil { return nil, err }
+  return LoadCartridge(rom, patches...)
+}
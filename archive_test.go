@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+import "log"
+import "bytes"
+import "archive/zip"
+import "compress/gzip"
+
+// Builds an iNES image for a 16 KiB NROM board that starts running the program
+// at $C000.
+func buildNROM(program []byte) []byte {
+  rom := make([]byte, 16 + PRG_BANK_SIZE + CHR_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x01\x01\x00\x00"))
+  copy(rom[16:], program)
+  // Reset vector
+  rom[16 + 0x3FFC] = 0x00
+  rom[16 + 0x3FFD] = 0xC0
+  return rom
+}
+
+func buildZip(files map[string][]byte) []byte {
+  buffer := &bytes.Buffer{}
+  archive := zip.NewWriter(buffer)
+  for name, contents := range files {
+    w, _ := archive.Create(name)
+    w.Write(contents)
+  }
+  archive.Close()
+  return buffer.Bytes()
+}
+
+func TestExtractROMFromZip(t *testing.T) {
+  first := buildNROM([]byte{0xA9, 1})
+  second := buildNROM([]byte{0xA9, 2})
+  data := buildZip(map[string][]byte{
+    "first.nes": first,
+    "second.NES": second,
+    "readme.txt": []byte("Not a ROM"),
+  })
+
+  entries, err := ArchiveEntries(data)
+  if err != nil || len(entries) != 2 {
+    log.Printf("Expecting two entries, but got %v (%v)", entries, err)
+    t.Fail()
+  }
+
+  if _, err := ExtractROM(data, ""); err == nil {
+    log.Printf("Expecting an error when no entry is picked from several")
+    t.Fail()
+  }
+
+  rom, err := ExtractROM(data, "second.NES")
+  if err != nil || !bytes.Equal(rom, second) {
+    log.Printf("Expecting the second ROM to be extracted (%v)", err)
+    t.Fail()
+  }
+
+  if _, err := ExtractROM(data, "third.nes"); err == nil {
+    log.Printf("Expecting an error for a missing entry")
+    t.Fail()
+  }
+}
+
+func TestExtractROMFromGzip(t *testing.T) {
+  original := buildNROM([]byte{0xA9, 1})
+  buffer := &bytes.Buffer{}
+  archive := gzip.NewWriter(buffer)
+  archive.Name = "game.nes"
+  archive.Write(original)
+  archive.Close()
+
+  entries, err := ArchiveEntries(buffer.Bytes())
+  if err != nil || len(entries) != 1 || entries[0] != "game.nes" {
+    log.Printf("Expecting a single game.nes entry, but got %v (%v)", entries, err)
+    t.Fail()
+  }
+
+  rom, err := ExtractROM(buffer.Bytes(), "")
+  if err != nil || !bytes.Equal(rom, original) {
+    log.Printf("Expecting the ROM to be extracted (%v)", err)
+    t.Fail()
+  }
+}
+
+func TestInsertCartridge(t *testing.T) {
+  data := buildZip(map[string][]byte{
+    "game.nes": buildNROM([]byte{0xA9, 42}),
+  })
+  rom, err := ExtractROM(data, "")
+  if err != nil { t.FailNow() }
+  cart, err := LoadCartridge(rom)
+  if err != nil { t.FailNow() }
+  mapper, err := MapperNew(cart)
+  if err != nil { t.FailNow() }
+
+  cpu := CPUNew()
+  cpu.InsertCartridge(mapper)
+  cpu.MovePCToResetVector()
+  if cpu.pc != 0xC000 {
+    log.Printf("Expecting program counter to be 0xC000, but got %X", cpu.pc)
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.A() != 42 {
+    log.Printf("Expecting accumulator to be 42, but got %d", cpu.A())
+    t.Fail()
+  }
+}
@@ -0,0 +1,112 @@
+package main
+
+// Something on the CPU bus that responds to reads.
+type Reader interface {
+  Read(address uint16) byte
+}
+
+// Something on the CPU bus that responds to writes.
+type Writer interface {
+  Write(address uint16, value byte)
+}
+
+// Something on the CPU bus that responds to both reads and writes.
+type Device interface {
+  Reader
+  Writer
+}
+
+// An address range on the CPU bus that nothing drives, like write-only
+// registers, so reads give back whatever was last on the data bus.
+type openBus struct {
+  cpu *CPU
+}
+
+func (o openBus) Read(address uint16) byte {
+  return o.cpu.DataBus()
+}
+
+// An address range on the CPU bus, and what's mapped to it.
+type mapping struct {
+  start, end uint16
+  reader Reader
+  writer Writer
+}
+
+func (m mapping) contains(address uint16) bool {
+  return address >= m.start && address <= m.end
+}
+
+// Maps a device onto the CPU bus, for both reads and writes, between the start
+// and end addresses (inclusive).
+//
+// Mappings made later take precedence over earlier ones that overlap them.
+// Anything left unmapped falls through to the CPU's memory.
+func (c* CPU) Map(start, end uint16, device Device) {
+  c.MapRead(start, end, device)
+  c.MapWrite(start, end, device)
+}
+
+// Maps a device onto the CPU bus for reads only.
+func (c* CPU) MapRead(start, end uint16, reader Reader) {
+  c.readers = append([]mapping{{start: start, end: end, reader: reader}}, c.readers...)
+}
+
+// Maps a device onto the CPU bus for writes only.
+func (c* CPU) MapWrite(start, end uint16, writer Writer) {
+  c.writers = append([]mapping{{start: start, end: end, writer: writer}}, c.writers...)
+}
+
+// Starts a bus access, catching the components up with it, unless it's made
+// while another access is underway, like the reads OAM DMA makes when it's
+// written to, or the ones the DMC makes as it's clocked. It gives back whether
+// the access is nested.
+func (c* CPU) startAccess() bool {
+  if c.accessing {
+    return true
+  }
+  c.accessing = true
+  c.catchUp()
+  return false
+}
+
+// Reads a byte off the CPU bus.
+func (c* CPU) read(address uint16) byte {
+  nested := c.startAccess()
+  defer func() { c.accessing = nested }()
+  c.lastWrite = false
+  c.lastRead = address
+  c.dataBus = c.readBus(address)
+  return c.dataBus
+}
+
+// Gets the value whatever's mapped to an address puts on the bus.
+func (c* CPU) readBus(address uint16) byte {
+  for _, m := range c.readers {
+    if m.contains(address) {
+      return m.reader.Read(address)
+    }
+  }
+  return c.memory.GetUint8At(address)
+}
+
+// Writes a byte onto the CPU bus.
+func (c* CPU) write(address uint16, value byte) {
+  nested := c.startAccess()
+  defer func() { c.accessing = nested }()
+  c.lastWrite = true
+  c.dataBus = value
+  for _, m := range c.writers {
+    if m.contains(address) {
+      m.writer.Write(address, value)
+      return
+    }
+  }
+  c.memory.SetUint8At(address, value)
+}
+
+// Reads two contiguous bytes off the CPU bus, interpreting them as a little
+// endian 16-bit integer.
+func (c* CPU) readUint16LE(address uint16) uint16 {
+  return uint16(c.read(address + 1)) << 8 | uint16(c.read(address))
+}
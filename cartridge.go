@@ -0,0 +1,186 @@
+package main
+
+import "bytes"
+import "errors"
+
+const (
+  // Sizes of the PRG and CHR banks, as counted by the iNES header.
+  PRG_BANK_SIZE = 0x4000
+  CHR_BANK_SIZE = 0x2000
+  // The largest PRG or CHR area a NES 2.0 header can describe with bank
+  // counts. Exponent-multiplier sizes over it get rejected.
+  MAX_ROM_SIZE = 0xEFF * PRG_BANK_SIZE
+)
+
+// The nametable mirroring arrangements a cartridge can wire up.
+const (
+  MIRROR_HORIZONTAL byte = iota
+  MIRROR_VERTICAL
+  MIRROR_FOUR_SCREEN
+  MIRROR_SINGLE_LOWER
+  MIRROR_SINGLE_UPPER
+)
+
+// Represents the contents of a cartridge, as described by a ROM image.
+type Cartridge struct {
+  prg, chr []byte
+  trainer []byte
+  // Whether CHR is RAM rather than ROM, in which case chr is zeroed out.
+  chrRAM bool
+  prgRAMSize int
+  mapper uint16
+  submapper byte
+  mirroring byte
+  battery bool
+  nes2 bool
+  // The TV system the ROM was made for, as one of the TIMING constants.
+  timing byte
+  // The input device the game expects, as one of the EXPANSION constants.
+  expansionDevice byte
+}
+
+// Loads a cartridge from an iNES, NES 2.0 or UNIF ROM image, applying the IPS,
+// UPS or BPS patches given, in order, before the header is parsed.
+//
+// The ROM image passed in is never modified.
+func LoadCartridge(rom []byte, patches ...[]byte) (*Cartridge, error) {
+  for _, patch := range patches {
+    patched, err := ApplyPatch(rom, patch)
+    if err != nil { return nil, err }
+    rom = patched
+  }
+
+  if bytes.HasPrefix(rom, []byte("NES\x1A")) {
+    return parseINES(rom)
+  }
+  if bytes.HasPrefix(rom, []byte("UNIF")) {
+    return parseUNIF(rom)
+  }
+  return nil, errors.New("Unknown ROM format")
+}
+
+// Works out the size of a ROM area from the NES 2.0 size fields, which either
+// count banks or use exponent-multiplier notation.
+//
+// Exponents go up to 63, which makes for sizes no ROM image could ever hold,
+// so anything over MAX_ROM_SIZE is an error rather than something to allocate.
+func nes2ROMSize(lsb byte, msb byte, bankSize int) (int, error) {
+  if msb == 0x0F {
+    exponent := uint(lsb >> 2)
+    multiplier := uint64(lsb & 3) * 2 + 1
+    if exponent >= 32 || uint64(1) << exponent * multiplier > MAX_ROM_SIZE {
+      return 0, errors.New("ROM size is out of range")
+    }
+    return int(uint64(1) << exponent * multiplier), nil
+  }
+  return (int(msb) << 8 | int(lsb)) * bankSize, nil
+}
+
+// Parses an iNES or NES 2.0 ROM image.
+func parseINES(rom []byte) (*Cartridge, error) {
+  if len(rom) < 16 {
+    return nil, errors.New("iNES header is truncated")
+  }
+  header := rom[:16]
+  cart := &Cartridge{}
+
+  flags6 := header[6]
+  flags7 := header[7]
+  cart.nes2 = flags7 & 0x0C == 0x08
+  cart.battery = flags6 & 0x02 != 0
+  cart.mapper = uint16(flags6 >> 4) | uint16(flags7 & 0xF0)
+  switch {
+  case flags6 & 0x08 != 0: cart.mirroring = MIRROR_FOUR_SCREEN
+  case flags6 & 0x01 != 0: cart.mirroring = MIRROR_VERTICAL
+  default: cart.mirroring = MIRROR_HORIZONTAL
+  }
+
+  prgSize := int(header[4]) * PRG_BANK_SIZE
+  chrSize := int(header[5]) * CHR_BANK_SIZE
+  cart.prgRAMSize = 0x2000
+  chrRAMSize := 0x2000
+
+  if cart.nes2 {
+    cart.mapper |= uint16(header[8] & 0x0F) << 8
+    cart.submapper = header[8] >> 4
+    var err error
+    prgSize, err = nes2ROMSize(header[4], header[9] & 0x0F, PRG_BANK_SIZE)
+    if err != nil { return nil, err }
+    chrSize, err = nes2ROMSize(header[5], header[9] >> 4, CHR_BANK_SIZE)
+    if err != nil { return nil, err }
+    cart.prgRAMSize = 0
+    if shift := header[10] & 0x0F; shift != 0 {
+      cart.prgRAMSize = 64 << shift
+    }
+    if shift := header[10] >> 4; shift != 0 {
+      cart.prgRAMSize += 64 << shift
+    }
+    chrRAMSize = 0
+    if shift := header[11] & 0x0F; shift != 0 {
+      chrRAMSize = 64 << shift
+    }
+    cart.timing = header[12] & 0x03
+    cart.expansionDevice = header[15] & 0x3F
+  } else if header[9] & 0x01 != 0 {
+    cart.timing = TIMING_PAL
+  }
+
+  offset := 16
+  if flags6 & 0x04 != 0 {
+    if len(rom) < offset + 512 {
+      return nil, errors.New("Trainer is truncated")
+    }
+    cart.trainer = rom[offset:offset+512]
+    offset += 512
+  }
+
+  if prgSize == 0 {
+    return nil, errors.New("ROM has no PRG data")
+  }
+  if len(rom) < offset + prgSize {
+    return nil, errors.New("PRG data is truncated")
+  }
+  cart.prg = make([]byte, prgSize)
+  copy(cart.prg, rom[offset:offset+prgSize])
+  offset += prgSize
+
+  if chrSize == 0 {
+    cart.chrRAM = true
+    cart.chr = make([]byte, chrRAMSize)
+  } else {
+    if len(rom) < offset + chrSize {
+      return nil, errors.New("CHR data is truncated")
+    }
+    cart.chr = make([]byte, chrSize)
+    copy(cart.chr, rom[offset:offset+chrSize])
+  }
+
+  return cart, nil
+}
+
+// Gets the iNES mapper number.
+func (c *Cartridge) MapperNumber() uint16 { return c.mapper }
+
+// Gets the NES 2.0 submapper number.
+func (c *Cartridge) Submapper() byte { return c.submapper }
+
+// Gets the nametable mirroring wired up by the cartridge.
+func (c *Cartridge) Mirroring() byte { return c.mirroring }
+
+// Gets whether or not the cartridge has battery-backed memory.
+func (c *Cartridge) Battery() bool { return c.battery }
+
+// Gets the TV system the ROM was made for, as one of the TIMING constants.
+func (c *Cartridge) Timing() byte { return c.timing }
+
+// Gets the input device the game expects, as one of the EXPANSION constants.
+func (c *Cartridge) ExpansionDevice() byte { return c.expansionDevice }
+
+// Gets the region the ROM should run as.
+func (c *Cartridge) Region() *Region { return RegionForTiming(c.timing) }
+
+// Gets the PRG ROM.
+func (c *Cartridge) PRG() []byte { return c.prg }
+
+// Gets the CHR ROM, or the CHR RAM if the cartridge has no CHR ROM.
+func (c *Cartridge) CHR() []byte { return c.chr }
@@ -0,0 +1,164 @@
+package main
+
+// Represents the whole console: the CPU, PPU and APU, the controller ports,
+// and the cartridge plugged into it.
+type Console struct {
+  cart *Cartridge
+  region *Region
+  sampleRate int
+  // What internal RAM gets filled with at power on, as one of the RAM_INIT
+  // patterns, and the seed for RAM_INIT_RANDOM.
+  ramInit byte
+  ramSeed int64
+
+  mapper Mapper
+  cpu *CPU
+  ppu *PPU
+  apu *APU
+  dma *OAMDMA
+  ports *Ports
+  scheduler *Scheduler
+  mixer *Mixer
+
+  // The samples mixed since the last frame was handed back.
+  samples []float32
+}
+
+// Initializes a new console with a cartridge plugged in, in the region the
+// cartridge was made for, mixing its audio at the given sample rate, and
+// powers it on. SetRegion can pick another region for the next power on.
+func ConsoleNew(cart *Cartridge, sampleRate int) (*Console, error) {
+  c := &Console{cart: cart, region: cart.Region(), sampleRate: sampleRate}
+  if err := c.PowerOn(); err != nil {
+    return nil, err
+  }
+  return c, nil
+}
+
+// Turns the console off and on again. Every component starts over from
+// scratch, cartridge included, along with the devices plugged into the
+// controller ports, which go back to those the cartridge expects.
+//
+// Those are new components, run by a new Scheduler, so anything that was added
+// to the old one, or that holds on to one of the old components, like a
+// Recorder on the APU, has to be set up again against the new ones.
+func (c *Console) PowerOn() error {
+  mapper, err := MapperNew(c.cart)
+  if err != nil { return err }
+  c.mapper = mapper
+
+  c.cpu = CPUNew()
+  c.cpu.InitRAM(c.ramInit, c.ramSeed)
+  c.ppu = PPUNew(mapper)
+  c.ppu.SetRegion(c.region)
+  c.apu = APUNew()
+  c.apu.SetRegion(c.region)
+  c.dma = OAMDMANew(c.cpu, c.ppu)
+  c.scheduler = SchedulerNew(c.region)
+  c.scheduler.Add(c.region.PPUDivider, c.ppu.Step)
+  c.scheduler.Add(c.region.CPUDivider, c.apu.Clock)
+  c.ports = PortsNew(c.cpu)
+  c.ports.PlugDefaults(c.cart.ExpansionDevice(), c.ppu)
+  c.mixer = MixerNew(c.apu, c.sampleRate, SAMPLE_FLOAT32)
+  c.mixer.SetCallback(func(samples []float32) {
+    c.samples = append(c.samples, samples...)
+  })
+  c.scheduler.Add(c.region.CPUDivider, c.mixer.Clock)
+  c.samples = nil
+
+  // Later mappings win, so the cartridge goes first, and the APU before the
+  // controllers, which take reads of $4017.
+  c.cpu.MapRAM()
+  c.cpu.InsertCartridge(mapper)
+  c.cpu.Map(0x2000, 0x3FFF, c.ppu)
+  c.apu.Attach(c.cpu)
+  c.cpu.MapWrite(0x4014, 0x4014, c.dma)
+  c.ports.Attach()
+  c.cpu.Connect(c.scheduler)
+  c.cpu.ConnectNMI(c.ppu)
+
+  c.cpu.MovePCToResetVector()
+  return nil
+}
+
+// Sets what internal RAM gets filled with the next time the console is powered
+// on, as one of the RAM_INIT patterns, along with the seed RAM_INIT_RANDOM
+// uses.
+func (c *Console) SetRAMInit(pattern byte, seed int64) {
+  c.ramInit = pattern
+  c.ramSeed = seed
+}
+
+// Sets the console's timing the next time it's powered on, in place of the one
+// the cartridge asks for, like for playing an NTSC game on a PAL console.
+func (c *Console) SetRegion(region *Region) {
+  c.region = region
+}
+
+// Presses the reset button, which resets the CPU, PPU and APU, but leaves
+// memory, the cartridge and the controller ports as they were.
+func (c *Console) Reset() {
+  c.cpu.Reset()
+  c.ppu.Reset()
+  c.apu.Reset()
+}
+
+// Gets the CPU.
+func (c *Console) CPU() *CPU { return c.cpu }
+
+// Gets the PPU.
+func (c *Console) PPU() *PPU { return c.ppu }
+
+// Gets the APU.
+func (c *Console) APU() *APU { return c.apu }
+
+// Gets the scheduler that runs the PPU, APU and mixer off the master clock.
+//
+// It only lasts until the console is next powered on, which replaces it along
+// with everything added to it.
+func (c *Console) Scheduler() *Scheduler { return c.scheduler }
+
+// Gets the controller ports.
+func (c *Console) Ports() *Ports { return c.ports }
+
+// Gets the cartridge plugged into the console.
+func (c *Console) Cartridge() *Cartridge { return c.cart }
+
+// Gets the console's timing.
+func (c *Console) Region() *Region { return c.region }
+
+// Runs the next instruction, along with everything else for as long as it
+// takes.
+func (c *Console) StepInstruction() error {
+  return c.cpu.Step()
+}
+
+// Runs until the PPU finishes the frame it's on, then gives back the frame, as
+// laid out by PPU.Framebuffer, and the mono samples mixed while it was being
+// drawn.
+//
+// The framebuffer is the PPU's own, so it gets drawn over by the next frame.
+func (c *Console) StepFrame() ([]uint16, []float32, error) {
+  frame := c.ppu.FrameCount()
+  for c.ppu.FrameCount() == frame {
+    if err := c.cpu.Step(); err != nil {
+      return nil, nil, err
+    }
+  }
+  samples := c.samples
+  c.samples = nil
+  return c.ppu.Framebuffer(), samples, nil
+}
+
+// Runs for the given number of frames, handing each one off to the given
+// function as it's finished, along with its samples, as StepFrame does.
+func (c *Console) RunFrames(frames int, callback func(framebuffer []uint16, samples []float32)) error {
+  for i := 0; i < frames; i++ {
+    framebuffer, samples, err := c.StepFrame()
+    if err != nil { return err }
+    if callback != nil {
+      callback(framebuffer, samples)
+    }
+  }
+  return nil
+}
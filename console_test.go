@@ -0,0 +1,253 @@
+package main
+
+import "log"
+import "testing"
+
+// Powers on a console with a 32 KiB NROM cartridge that's nothing but NOPs.
+func initConsole(t *testing.T) *Console {
+  return initConsoleWithProgram(t, nil)
+}
+
+// Powers on a console with a 32 KiB NROM cartridge that runs the given program
+// from $8000, followed by NOPs.
+func initConsoleWithProgram(t *testing.T, program []byte) *Console {
+  rom := make([]byte, 16 + 2 * PRG_BANK_SIZE + CHR_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x02\x01\x00\x00"))
+  for i := 0; i < 2 * PRG_BANK_SIZE; i++ {
+    rom[16 + i] = 0xEA
+  }
+  copy(rom[16:], program)
+  // NMI, reset and IRQ vectors
+  copy(rom[16 + 0x7FFA:], []byte{0x00, 0x80, 0x00, 0x80, 0x00, 0x80})
+
+  cart, err := LoadCartridge(rom)
+  if err != nil { t.FailNow() }
+  console, err := ConsoleNew(cart, 44100)
+  if err != nil {
+    log.Printf("Expecting the console to power on (%v)", err)
+    t.FailNow()
+  }
+  return console
+}
+
+func TestConsoleStepInstruction(t *testing.T) {
+  console := initConsole(t)
+  if console.CPU().pc != 0x8000 {
+    log.Printf("Expecting program counter to be 8000, but got %X", console.CPU().pc)
+    t.Fail()
+  }
+  if err := console.StepInstruction(); err != nil {
+    log.Printf("Expecting a NOP to run (%v)", err)
+    t.Fail()
+  }
+  if console.CPU().pc != 0x8001 || console.CPU().Cycles() != 2 {
+    log.Printf("Expecting a NOP to take 2 cycles, but got %d", console.CPU().Cycles())
+    t.Fail()
+  }
+  // Three dots for every CPU cycle.
+  if console.PPU().dot != 6 {
+    log.Printf("Expecting the PPU to be at dot 6, but got %d", console.PPU().dot)
+    t.Fail()
+  }
+}
+
+func TestConsoleStepFrame(t *testing.T) {
+  console := initConsole(t)
+  var frames int
+  err := console.RunFrames(1, func(framebuffer []uint16, samples []float32) {
+    frames++
+    if len(framebuffer) != FRAME_WIDTH * FRAME_HEIGHT {
+      log.Printf("Expecting a whole frame, but got %d pixels", len(framebuffer))
+      t.Fail()
+    }
+    // 44100 Hz at about 60 frames a second.
+    if len(samples) < 730 || len(samples) > 740 {
+      log.Printf("Expecting a frame's worth of samples, but got %d", len(samples))
+      t.Fail()
+    }
+  })
+  if err != nil || frames != 1 {
+    log.Printf("Expecting a frame to run (%v)", err)
+    t.Fail()
+  }
+  if console.PPU().FrameCount() != 1 || console.PPU().scanline != 0 {
+    log.Printf("Expecting to stop at the start of the next frame")
+    t.Fail()
+  }
+}
+
+func TestConsoleReset(t *testing.T) {
+  console := initConsole(t)
+  cpu := console.CPU()
+  for i := 0; i < 10; i++ {
+    console.StepInstruction()
+  }
+  cpu.memory[0x0200] = 0x42
+  console.PPU().Write(0x2000, CTRL_NMI)
+  sp := cpu.sp
+
+  cycles := cpu.Cycles()
+  console.Reset()
+  if cpu.pc != 0x8000 || cpu.sp != sp - 3 || cpu.p & I == 0 {
+    log.Printf("Expecting a reset to jump to the reset vector, but PC = %X, SP = %X, P = %X", cpu.pc, cpu.sp, cpu.p)
+    t.Fail()
+  }
+  // The rest of the console gets clocked through the reset sequence too.
+  if cpu.Cycles() != cycles + 7 || console.PPU().clock != 3 * cpu.Cycles() || console.APU().cycles != cpu.Cycles() {
+    log.Printf("Expecting the PPU and APU to keep up with the 7 cycles of the reset, but got %d dots and %d cycles", console.PPU().clock, console.APU().cycles)
+    t.Fail()
+  }
+  if console.PPU().ctrl != 0 {
+    log.Printf("Expecting PPUCTRL to be cleared")
+    t.Fail()
+  }
+  if cpu.memory[0x0200] != 0x42 {
+    log.Printf("Expecting RAM to be kept")
+    t.Fail()
+  }
+}
+
+func TestConsolePowerOn(t *testing.T) {
+  console := initConsole(t)
+  console.SetRAMInit(RAM_INIT_FF, 0)
+  console.Reset()
+  if console.CPU().memory[0x0100] != 0x00 {
+    log.Printf("Expecting a reset to leave RAM alone")
+    t.Fail()
+  }
+  if err := console.PowerOn(); err != nil { t.FailNow() }
+  cpu := console.CPU()
+  if cpu.memory[0x0100] != 0xFF {
+    log.Printf("Expecting RAM to be filled with FF at power on")
+    t.Fail()
+  }
+  if cpu.pc != 0x8000 || cpu.sp != 0xFD || cpu.p != 0x34 {
+    log.Printf("Expecting the power on state, but PC = %X, SP = %X, P = %X", cpu.pc, cpu.sp, cpu.p)
+    t.Fail()
+  }
+}
+
+func TestConsoleProgram(t *testing.T) {
+  program := []byte{
+    0xA9, 0x80, 0x8D, 0x00, 0x20, // LDA #$80, STA $2000
+    0xA9, 0x11, 0x8D, 0x00, 0x02, // LDA #$11, STA $0200
+    0xA9, 0x22, 0x8D, 0xFF, 0x02, // LDA #$22, STA $02FF
+    0xA9, 0x02, 0x8D, 0x14, 0x40, // LDA #$02, STA $4014
+    0xA9, 0x01, 0x8D, 0x16, 0x40, // LDA #$01, STA $4016
+    0xA9, 0x00, 0x8D, 0x16, 0x40, // LDA #$00, STA $4016
+  }
+  for i := 0; i < 8; i++ {
+    program = append(program, 0xAD, 0x16, 0x40) // LDA $4016
+  }
+  console := initConsoleWithProgram(t, program)
+  console.Ports().SetButtons(0, BUTTON_A | BUTTON_START)
+  cpu := console.CPU()
+  ppu := console.PPU()
+
+  step := func() {
+    if err := console.StepInstruction(); err != nil {
+      log.Printf("Expecting the program to run (%v)", err)
+      t.FailNow()
+    }
+  }
+  for i := 0; i < 6; i++ {
+    step()
+  }
+  if ppu.ctrl != 0x80 {
+    log.Printf("Expecting PPUCTRL to be 80, but got %X", ppu.ctrl)
+    t.Fail()
+  }
+
+  // The STA takes 4 cycles, ending on cycle 24, which is even, so the DMA
+  // takes 513 more.
+  step()
+  cycles := cpu.Cycles()
+  step()
+  if stall := cpu.Cycles() - cycles - 4; cycles != 20 || stall != 513 {
+    log.Printf("Expecting the DMA to take 513 cycles from cycle 24, but took %d from %d", stall, cycles + 4)
+    t.Fail()
+  }
+  if ppu.oam[0x00] != 0x11 || ppu.oam[0xFF] != 0x22 {
+    log.Printf("Expecting OAM to hold page $02, but got %X and %X", ppu.oam[0x00], ppu.oam[0xFF])
+    t.Fail()
+  }
+
+  for i := 0; i < 4; i++ {
+    step()
+  }
+  var buttons byte
+  for i := uint(0); i < 8; i++ {
+    step()
+    if cpu.A() & 0xE0 != 0x40 {
+      log.Printf("Expecting the open bus bits to be 40, but got %X", cpu.A() & 0xE0)
+      t.Fail()
+    }
+    buttons |= (cpu.A() & 1) << i
+  }
+  if buttons != BUTTON_A | BUTTON_START {
+    log.Printf("Expecting A and Start to be read back, but got %X", buttons)
+    t.Fail()
+  }
+}
+
+func TestConsoleSetRegion(t *testing.T) {
+  console := initConsole(t)
+  if console.Region() != RegionNTSC {
+    log.Printf("Expecting the region in the header, but got %s", console.Region().Name)
+    t.Fail()
+  }
+
+  console.SetRegion(RegionPAL)
+  if err := console.PowerOn(); err != nil { t.FailNow() }
+  scheduler := console.Scheduler()
+  if scheduler.region != RegionPAL || scheduler.components[0].divider != 5 || scheduler.components[1].divider != 16 {
+    log.Printf("Expecting the scheduler to run the PPU and APU off PAL dividers")
+    t.Fail()
+  }
+  if console.PPU().Region() != RegionPAL || console.APU().region != RegionPAL {
+    log.Printf("Expecting the PPU and APU to be switched to PAL")
+    t.Fail()
+  }
+
+  // A PAL frame runs for 312 scanlines.
+  ppu := console.PPU()
+  var last int
+  for ppu.FrameCount() == 0 {
+    if err := console.StepInstruction(); err != nil { t.FailNow() }
+    if ppu.FrameCount() == 0 && ppu.scanline > last {
+      last = ppu.scanline
+    }
+  }
+  if last != 311 || ppu.clock - uint64(ppu.dot) != 312 * DOTS_PER_SCANLINE {
+    log.Printf("Expecting 312 scanlines, but got %d", last + 1)
+    t.Fail()
+  }
+}
+
+func TestConsoleRAMMirrors(t *testing.T) {
+  console := initConsoleWithProgram(t, []byte{
+    0xA9, 0x42, 0x8D, 0x00, 0x00, // LDA #$42, STA $0000
+    0xA9, 0x00,                   // LDA #$00
+    0xAD, 0x00, 0x08,             // LDA $0800
+    0xA2, 0x24, 0x8E, 0xFF, 0x1F, // LDX #$24, STX $1FFF
+  })
+  for i := 0; i < 6; i++ {
+    if err := console.StepInstruction(); err != nil { t.FailNow() }
+  }
+  cpu := console.CPU()
+  if cpu.A() != 0x42 {
+    log.Printf("Expecting $0800 to mirror $0000, but got %X", cpu.A())
+    t.Fail()
+  }
+  if cpu.memory[0x07FF] != 0x24 {
+    log.Printf("Expecting $1FFF to mirror $07FF, but got %X", cpu.memory[0x07FF])
+    t.Fail()
+  }
+
+  console.SetRAMInit(RAM_INIT_FF, 0)
+  if err := console.PowerOn(); err != nil { t.FailNow() }
+  if value := console.CPU().read(0x1800); value != 0xFF {
+    log.Printf("Expecting the mirrors to be filled at power on, but got %X", value)
+    t.Fail()
+  }
+}
@@ -0,0 +1,248 @@
+package main
+
+// The buttons on a standard controller, in the order they're shifted out.
+const (
+  BUTTON_A byte = 1 << iota
+  BUTTON_B
+  BUTTON_SELECT
+  BUTTON_START
+  BUTTON_UP
+  BUTTON_DOWN
+  BUTTON_LEFT
+  BUTTON_RIGHT
+)
+
+// The input devices a game can expect, as given by the NES 2.0 header.
+const (
+  EXPANSION_UNSPECIFIED byte = 0x00
+  EXPANSION_STANDARD byte = 0x01
+  EXPANSION_FOUR_SCORE byte = 0x02
+  EXPANSION_FAMICOM_FOUR_PLAYERS byte = 0x03
+  EXPANSION_ZAPPER byte = 0x08
+  EXPANSION_TWO_ZAPPERS byte = 0x09
+  EXPANSION_POWER_PAD_A byte = 0x0B
+  EXPANSION_POWER_PAD_B byte = 0x0C
+  EXPANSION_VAUS_NES byte = 0x0F
+  EXPANSION_VAUS_FAMICOM byte = 0x10
+  EXPANSION_FAMILY_BASIC_KEYBOARD byte = 0x23
+)
+
+// Something plugged into one of the controller ports, or into the Famicom's
+// expansion port.
+type InputDevice interface {
+  // Takes a value written to $4016. Bit 0 is the strobe that goes out to
+  // every port, and bits 1 and 2 only go to the expansion port.
+  Write(value byte)
+  // Gets the bits the device drives on a read of $4016 or $4017, which are
+  // among bits 0 to 4.
+  Read(address uint16) byte
+}
+
+// An input device that's made up of standard controllers, whose buttons can
+// be set by player.
+type controllerDevice interface {
+  // Gets the controllers, in the order of the players they belong to.
+  controllers() []*StandardController
+}
+
+// Represents a standard controller, which is a 4021 shift register that
+// latches the buttons while the strobe is high, and shifts them out one at a
+// time after.
+type StandardController struct {
+  buttons byte
+  strobe bool
+  shift byte
+  // How many bits have been shifted out since the buttons were latched. Once
+  // all 8 have been, the register reads back as 1s.
+  reads int
+}
+
+// Initializes a new standard controller, with nothing pressed.
+func StandardControllerNew() *StandardController {
+  return &StandardController{}
+}
+
+// Sets the buttons being held down, as BUTTON bits.
+func (s *StandardController) SetButtons(buttons byte) {
+  s.buttons = buttons
+}
+
+// Gets the buttons being held down.
+func (s *StandardController) Buttons() byte { return s.buttons }
+
+func (s *StandardController) controllers() []*StandardController {
+  return []*StandardController{s}
+}
+
+// Takes the strobe.
+func (s *StandardController) Write(value byte) {
+  s.strobe = value & 1 != 0
+  if s.strobe {
+    s.latch()
+  }
+}
+
+// Latches the buttons into the shift register.
+func (s *StandardController) latch() {
+  s.shift = s.buttons
+  s.reads = 0
+}
+
+// Shifts the next bit out, onto bit 0.
+func (s *StandardController) Read(address uint16) byte {
+  // While the strobe is high, the register keeps reloading, so it's always A.
+  if s.strobe {
+    s.latch()
+  }
+  return s.next()
+}
+
+// Shifts the next bit out.
+func (s *StandardController) next() byte {
+  if s.reads >= 8 {
+    return 1
+  }
+  bit := s.shift & 1
+  s.shift >>= 1
+  if !s.strobe {
+    s.reads++
+  }
+  return bit
+}
+
+// Represents the two controller ports and the Famicom's expansion port, at
+// $4016 and $4017.
+type Ports struct {
+  cpu *CPU
+  ports [2]InputDevice
+  expansion InputDevice
+}
+
+// Initializes the ports, with a standard controller plugged into each.
+func PortsNew(cpu *CPU) *Ports {
+  return &Ports{
+    cpu: cpu,
+    ports: [2]InputDevice{StandardControllerNew(), StandardControllerNew()},
+  }
+}
+
+// Maps the ports onto the CPU bus. Writes to $4017 go to the APU instead.
+func (p *Ports) Attach() {
+  p.cpu.MapRead(0x4016, 0x4017, p)
+  p.cpu.MapWrite(0x4016, 0x4016, p)
+}
+
+// Plugs a device into one of the controller ports, 0 or 1, or unplugs
+// whatever's there if it's nil.
+func (p *Ports) Plug(port int, device InputDevice) {
+  p.ports[port] = device
+}
+
+// Plugs a device into the expansion port, or unplugs whatever's there if it's
+// nil.
+func (p *Ports) PlugExpansion(device InputDevice) {
+  p.expansion = device
+}
+
+// Plugs in the devices that go with one of the EXPANSION constants, as found
+// in the NES 2.0 header, leaving standard controllers in any port it doesn't
+// use. The PPU is what Zappers see.
+//
+// It gives back false, leaving standard controllers plugged in, for devices
+// that aren't supported.
+func (p *Ports) PlugDefaults(expansion byte, ppu *PPU) bool {
+  p.Plug(0, StandardControllerNew())
+  p.Plug(1, StandardControllerNew())
+  p.PlugExpansion(nil)
+
+  switch expansion {
+  case EXPANSION_UNSPECIFIED, EXPANSION_STANDARD:
+  case EXPANSION_FOUR_SCORE:
+    p.PlugFourScore(FourScoreNew())
+  case EXPANSION_FAMICOM_FOUR_PLAYERS:
+    p.PlugExpansion(FamicomFourPlayersNew())
+  case EXPANSION_ZAPPER:
+    p.Plug(1, ZapperNew(ppu))
+  case EXPANSION_TWO_ZAPPERS:
+    p.Plug(0, ZapperNew(ppu))
+    p.Plug(1, ZapperNew(ppu))
+  case EXPANSION_POWER_PAD_A, EXPANSION_POWER_PAD_B:
+    p.Plug(1, PowerPadNew())
+  case EXPANSION_VAUS_NES:
+    p.Plug(1, VausNew(false))
+  case EXPANSION_VAUS_FAMICOM:
+    p.PlugExpansion(VausNew(true))
+  case EXPANSION_FAMILY_BASIC_KEYBOARD:
+    p.PlugExpansion(FamilyBasicKeyboardNew())
+  default:
+    return false
+  }
+  return true
+}
+
+// Gets the device plugged into one of the controller ports.
+func (p *Ports) Device(port int) InputDevice { return p.ports[port] }
+
+// Gets the device plugged into the expansion port.
+func (p *Ports) Expansion() InputDevice { return p.expansion }
+
+// Gets a player's controller, counting from 0. Players 1 and 2 get the first
+// controllers in ports 1 and 2, and any others get the rest in turn, after
+// which come those on the expansion port.
+func (p *Ports) Controller(player int) *StandardController {
+  var controllers [2][]*StandardController
+  for i, device := range p.ports {
+    if device, ok := device.(controllerDevice); ok {
+      controllers[i] = device.controllers()
+    }
+  }
+  var players []*StandardController
+  for i := 0; i < len(controllers[0]) || i < len(controllers[1]); i++ {
+    for port := range controllers {
+      if i < len(controllers[port]) {
+        players = append(players, controllers[port][i])
+      }
+    }
+  }
+  if device, ok := p.expansion.(controllerDevice); ok {
+    players = append(players, device.controllers()...)
+  }
+
+  if player < 0 || player >= len(players) {
+    return nil
+  }
+  return players[player]
+}
+
+// Sets the buttons a player is holding down, as BUTTON bits. It does nothing
+// if the player has no controller.
+func (p *Ports) SetButtons(player int, buttons byte) {
+  if controller := p.Controller(player); controller != nil {
+    controller.SetButtons(buttons)
+  }
+}
+
+// Reads $4016 or $4017. The devices only drive the low 5 bits, so the rest
+// are whatever was last on the data bus.
+func (p *Ports) Read(address uint16) byte {
+  value := p.cpu.DataBus() & 0xE0
+  if device := p.ports[address & 1]; device != nil {
+    value |= device.Read(address) & 0x1F
+  }
+  if p.expansion != nil {
+    value |= p.expansion.Read(address) & 0x1F
+  }
+  return value
+}
+
+// Writes $4016, which goes out to every port.
+func (p *Ports) Write(address uint16, value byte) {
+  for _, device := range p.ports {
+    if device != nil {
+      device.Write(value)
+    }
+  }
+  if p.expansion != nil {
+    p.expansion.Write(value)
+  }
+}
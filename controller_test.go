@@ -0,0 +1,297 @@
+package main
+
+import "testing"
+import "log"
+
+func initPorts() (*CPU, *Ports) {
+  cpu := CPUNew()
+  ports := PortsNew(cpu)
+  ports.Attach()
+  return cpu, ports
+}
+
+// Strobes the controllers, and reads n bits off one of the ports.
+func readPort(cpu *CPU, address uint16, n int) []byte {
+  cpu.write(0x4016, 1)
+  cpu.write(0x4016, 0)
+  bits := make([]byte, n)
+  for i := range bits {
+    bits[i] = cpu.read(address) & 0x1F
+  }
+  return bits
+}
+
+func TestStandardController(t *testing.T) {
+  cpu, ports := initPorts()
+  ports.SetButtons(0, BUTTON_A | BUTTON_START | BUTTON_RIGHT)
+  ports.SetButtons(1, BUTTON_B)
+
+  expected := []byte{1, 0, 0, 1, 0, 0, 0, 1, 1, 1}
+  for i, bit := range readPort(cpu, 0x4016, 10) {
+    if bit != expected[i] {
+      log.Printf("Expecting bit %d of port 1 to be %d, but got %d", i, expected[i], bit)
+      t.Fail()
+    }
+  }
+  if bits := readPort(cpu, 0x4017, 2); bits[0] != 0 || bits[1] != 1 {
+    log.Printf("Expecting B on port 2, but got %v", bits)
+    t.Fail()
+  }
+
+  // While the strobe is high, it keeps reading A.
+  cpu.write(0x4016, 1)
+  for i := 0; i < 3; i++ {
+    if cpu.read(0x4016) & 1 != 1 {
+      log.Printf("Expecting A to be read while the strobe is high")
+      t.Fail()
+    }
+  }
+
+  // Players without a controller are ignored.
+  ports.SetButtons(2, BUTTON_A)
+  if ports.Controller(2) != nil {
+    log.Printf("Expecting no third controller")
+    t.Fail()
+  }
+}
+
+func TestPortsOpenBus(t *testing.T) {
+  cpu, ports := initPorts()
+  ports.SetButtons(0, BUTTON_A)
+
+  // The top bits come from whatever was last on the bus, which is usually the
+  // high byte of the address.
+  cpu.memory.SetUint8At(0x0000, 0x40)
+  cpu.write(0x4016, 1)
+  cpu.read(0x0000)
+  if value := cpu.read(0x4016); value != 0x41 {
+    log.Printf("Expecting $41, but got %X", value)
+    t.Fail()
+  }
+
+  ports.Plug(1, nil)
+  cpu.memory.SetUint8At(0x0000, 0xFF)
+  cpu.read(0x0000)
+  if value := cpu.read(0x4017); value != 0xE0 {
+    log.Printf("Expecting just the open bus bits with nothing plugged in, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestZapper(t *testing.T) {
+  cpu, ports := initPorts()
+  ppu := initPPU()
+  zapper := ZapperNew(ppu)
+  ports.Plug(1, zapper)
+
+  // The screen's all white, though nothing's been drawn yet.
+  ppu.palette[0] = 0x30
+  zapper.Aim(100, 100)
+  stepTo := func(scanline int) {
+    for ppu.scanline != scanline {
+      ppu.Step()
+    }
+  }
+
+  // Before the beam gets to the box, there's no light.
+  stepTo(90)
+  if cpu.read(0x4017) & ZAPPER_LIGHT == 0 {
+    log.Printf("Expecting no light before the beam gets to the box")
+    t.Fail()
+  }
+  // Just after it's drawn, there is.
+  stepTo(101)
+  if cpu.read(0x4017) & ZAPPER_LIGHT != 0 {
+    log.Printf("Expecting light just after the beam draws the box")
+    t.Fail()
+  }
+  // And then it fades.
+  stepTo(130)
+  if cpu.read(0x4017) & ZAPPER_LIGHT == 0 {
+    log.Printf("Expecting the light to fade once the beam has moved on")
+    t.Fail()
+  }
+
+  // Aiming at black sees nothing.
+  for y := 120; y < 130; y++ {
+    for x := 10; x < 30; x++ {
+      ppu.framebuffer[y * FRAME_WIDTH + x] = 0x0F
+    }
+  }
+  zapper.Aim(20, 125)
+  if cpu.read(0x4017) & ZAPPER_LIGHT == 0 {
+    log.Printf("Expecting no light from black pixels")
+    t.Fail()
+  }
+
+  if cpu.read(0x4017) & ZAPPER_TRIGGER != 0 {
+    log.Printf("Expecting the trigger to be released")
+    t.Fail()
+  }
+  zapper.SetTrigger(true)
+  if cpu.read(0x4017) & ZAPPER_TRIGGER == 0 {
+    log.Printf("Expecting the trigger to be pulled")
+    t.Fail()
+  }
+}
+
+func TestFourScore(t *testing.T) {
+  cpu, ports := initPorts()
+  ports.PlugFourScore(FourScoreNew())
+  for player := 0; player < 4; player++ {
+    ports.SetButtons(player, 1 << uint(player))
+  }
+
+  // Player 1, player 3, then the signature.
+  expected := uint32(0x01) | uint32(0x04) << 8 | uint32(0x08) << 16
+  for i, bit := range readPort(cpu, 0x4016, 26) {
+    want := byte(expected >> uint(i) & 1)
+    if i >= 24 {
+      want = 1
+    }
+    if bit != want {
+      log.Printf("Expecting bit %d of $4016 to be %d, but got %d", i, want, bit)
+      t.Fail()
+    }
+  }
+
+  // Player 2, player 4, then the other signature.
+  expected = uint32(0x02) | uint32(0x08) << 8 | uint32(0x04) << 16
+  for i, bit := range readPort(cpu, 0x4017, 24) {
+    if bit != byte(expected >> uint(i) & 1) {
+      log.Printf("Expecting bit %d of $4017 to be %d, but got %d", i, expected >> uint(i) & 1, bit)
+      t.Fail()
+    }
+  }
+}
+
+func TestFamicomFourPlayers(t *testing.T) {
+  cpu, ports := initPorts()
+  ports.PlugExpansion(FamicomFourPlayersNew())
+  ports.SetButtons(0, BUTTON_A)
+  ports.SetButtons(2, BUTTON_B)
+  ports.SetButtons(3, BUTTON_A)
+
+  bits := readPort(cpu, 0x4016, 2)
+  if bits[0] != 0x01 || bits[1] != 0x02 {
+    log.Printf("Expecting player 1's A on bit 0 and player 3's B on bit 1, but got %v", bits)
+    t.Fail()
+  }
+  bits = readPort(cpu, 0x4017, 1)
+  if bits[0] != 0x02 {
+    log.Printf("Expecting player 4's A on bit 1 of $4017, but got %v", bits)
+    t.Fail()
+  }
+}
+
+func TestVaus(t *testing.T) {
+  cpu, ports := initPorts()
+  vaus := VausNew(false)
+  ports.Plug(1, vaus)
+  vaus.SetPosition(0xA5)
+  vaus.SetButton(true)
+
+  // The position goes out inverted, from the top bit down, on bit 4.
+  var position byte
+  for i, bits := range readPort(cpu, 0x4017, 8) {
+    if bits & 0x08 == 0 {
+      log.Printf("Expecting the button to be pressed on read %d", i)
+      t.Fail()
+    }
+    position = position << 1 | bits >> 4 & 1
+  }
+  if ^position != 0xA5 {
+    log.Printf("Expecting the position to be A5, but got %X", ^position)
+    t.Fail()
+  }
+
+  // The Famicom one puts them on bit 1 of $4016 and $4017 instead.
+  ports.Plug(1, StandardControllerNew())
+  famicom := VausNew(true)
+  famicom.SetPosition(0x80)
+  ports.PlugExpansion(famicom)
+  if bits := readPort(cpu, 0x4017, 2); bits[0] != 0 || bits[1] != 0x02 {
+    log.Printf("Expecting the position inverted on bit 1, but got %v", bits)
+    t.Fail()
+  }
+}
+
+func TestPowerPad(t *testing.T) {
+  cpu, ports := initPorts()
+  pad := PowerPadNew()
+  ports.Plug(1, pad)
+  // Buttons 1, 3 and 7.
+  pad.SetButtons(1 << 0 | 1 << 2 | 1 << 6)
+
+  high := []byte{0, 1, 0, 0, 0, 0, 0, 1, 1}
+  low := []byte{0, 1, 0, 0, 1, 1}
+  for i, bits := range readPort(cpu, 0x4017, 9) {
+    if bits >> 4 & 1 != high[i] {
+      log.Printf("Expecting bit 4 of read %d to be %d", i, high[i])
+      t.Fail()
+    }
+    if i < len(low) && bits >> 3 & 1 != low[i] {
+      log.Printf("Expecting bit 3 of read %d to be %d", i, low[i])
+      t.Fail()
+    }
+  }
+}
+
+func TestFamilyBasicKeyboard(t *testing.T) {
+  cpu, ports := initPorts()
+  keyboard := FamilyBasicKeyboardNew()
+  ports.PlugExpansion(keyboard)
+  keyboard.SetKey("RETURN", true)
+  keyboard.SetKey("SPACE", true)
+  if keyboard.SetKey("NOSUCHKEY", true) {
+    log.Printf("Expecting unknown keys to be rejected")
+    t.Fail()
+  }
+
+  // Row 0, column 0 has RETURN on bit 3.
+  cpu.write(0x4016, 0x05)
+  if value := cpu.read(0x4017) & 0x1E; value != 0x16 {
+    log.Printf("Expecting RETURN to read as a 0 on bit 3, but got %X", value)
+    t.Fail()
+  }
+
+  // Going through the columns gets to row 8, column 1, which has SPACE on
+  // bit 3.
+  for row := 0; row < 8; row++ {
+    cpu.write(0x4016, 0x06)
+    cpu.write(0x4016, 0x04)
+  }
+  cpu.write(0x4016, 0x06)
+  if value := cpu.read(0x4017) & 0x1E; value != 0x16 {
+    log.Printf("Expecting SPACE to read as a 0 on bit 3, but got %X", value)
+    t.Fail()
+  }
+
+  // With the keyboard disabled, it reads nothing.
+  cpu.write(0x4016, 0x00)
+  if value := cpu.read(0x4017) & 0x1E; value != 0 {
+    log.Printf("Expecting nothing from a disabled keyboard, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestPlugDefaults(t *testing.T) {
+  rom := make([]byte, 16 + PRG_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x01\x00\x01\x08"))
+  rom[15] = EXPANSION_VAUS_NES
+  cart, _ := LoadCartridge(rom)
+
+  _, ports := initPorts()
+  if !ports.PlugDefaults(cart.ExpansionDevice(), initPPU()) {
+    log.Printf("Expecting the Vaus to be supported")
+    t.Fail()
+  }
+  if _, ok := ports.Device(1).(*Vaus); !ok {
+    log.Printf("Expecting a Vaus in port 2")
+    t.Fail()
+  }
+  if ports.PlugDefaults(0x3F, nil) {
+    log.Printf("Expecting an unknown device not to be supported")
+    t.Fail()
+  }
+}
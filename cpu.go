@@ -20,24 +20,87 @@ type CPU struct {
   pc uint16
   sp, a, x, y, p byte
   cycles int
+  // Cycles the CPU has to sit out, on top of those of the instructions it
+  // runs, because the DMC has taken the bus.
+  stall int
+  // The number of cycles elapsed since power on.
+  count uint64
   memory Memory
+  // Devices mapped onto the CPU bus.
+  readers, writers []mapping
+  // Components that get clocked alongside the CPU.
+  clocked []Clocked
+  // The NMI line, its level as of the last cycle, and whether or not an edge
+  // on it is waiting to be serviced.
+  nmiSource NMISource
+  nmiLine, nmiPending bool
+  // The components sharing the IRQ line.
+  irqSources []IRQSource
+  // Whether the CPU is sitting out a stall, and whether its last access to the
+  // bus was a write or else where the last read was from, which decide how a
+  // DMC fetch lands.
+  stalling bool
+  lastWrite bool
+  lastRead uint16
+  // The last value on the data bus, which is what devices that don't drive
+  // every bit leave behind on the rest.
+  dataBus byte
+  // Transfers waiting to take the bus over once the current instruction is
+  // done.
+  transfers []func()
+  // Whether an instruction is being run by Step, in which case the components
+  // get caught up with the CPU on every bus access, along with the number of
+  // accesses it has made so far, and whether one is underway.
+  running bool
+  accesses int
+  accessing bool
 }
 
-// Initializes a new CPU.
+// A component that advances alongside the CPU, once per CPU cycle.
+type Clocked interface {
+  Clock()
+}
+
+// A component that drives the CPU's NMI line.
+type NMISource interface {
+  // Gets whether or not the line is being asserted.
+  NMI() bool
+}
+
+// A component that drives the CPU's IRQ line. The line is shared, and is held
+// for as long as any of them asserts it.
+type IRQSource interface {
+  // Gets whether or not the line is being asserted.
+  IRQ() bool
+}
+
+// Initializes a new CPU, in the state it's left in by the reset sequence it
+// goes through at power on: the stack pointer has come down by 3 from 0, and
+// interrupts are disabled, along with the two unused bits of the status being
+// set.
+//
+// The program counter is left for MovePCToResetVector to load, once there's
+// something mapped onto the bus for it to load from.
 func CPUNew() *CPU {
   return &CPU{
-    // TODO: check to see whether or not the initial values are correct.
     pc: 0,
-    sp: 0xFF,
+    sp: 0xFD,
     a: 0,
     x: 0,
     y: 0,
-    p: 0,
+    p: 0x34,
     cycles: 0,
     memory: Memory{},
   }
 }
 
+// Fills the 2 KiB of internal RAM, from $0000 to $07FF, with one of the
+// RAM_INIT patterns. The seed is only used by RAM_INIT_RANDOM, which gives the
+// same contents for the same seed.
+func (c* CPU) InitRAM(pattern byte, seed int64) {
+  c.memory.fill(0x0000, RAM_SIZE, pattern, seed)
+}
+
 func (c* CPU) SetInstructions(instructions []byte) {
   // TODO: the instruction set is not just a set of bytes, but a bit more
   // complicated than that.
@@ -45,6 +108,85 @@ func (c* CPU) SetInstructions(instructions []byte) {
   c.memory.SetInstructions(instructions)
 }
 
+// Maps internal RAM onto the CPU bus, mirrored from $0000 up to $1FFF.
+func (c* CPU) MapRAM() {
+  c.Map(0x0000, 0x1FFF, ram{&c.memory})
+}
+
+// Plugs a cartridge into the console, mapping it onto the CPU bus from $4020
+// upwards.
+func (c* CPU) InsertCartridge(mapper Mapper) {
+  c.Map(0x4020, 0xFFFF, mapper)
+}
+
+// Has a component get clocked once for every CPU cycle.
+func (c* CPU) Connect(component Clocked) {
+  c.clocked = append(c.clocked, component)
+}
+
+// Wires a component up to the NMI line.
+func (c* CPU) ConnectNMI(source NMISource) {
+  c.nmiSource = source
+}
+
+// Wires a component up to the IRQ line.
+func (c* CPU) ConnectIRQ(source IRQSource) {
+  c.irqSources = append(c.irqSources, source)
+}
+
+// Gets whether or not anything is asserting the IRQ line.
+func (c* CPU) irq() bool {
+  for _, source := range c.irqSources {
+    if source.IRQ() {
+      return true
+    }
+  }
+  return false
+}
+
+// Reads a byte for the APU's DMC channel, halting the CPU while it has the
+// bus.
+//
+// That normally takes 4 cycles, but only 3 if the CPU was halted on a write,
+// and 2 if it's in the middle of an OAM DMA. If it was halted on a read, it
+// repeats it once the fetch is done, which clocks the controllers an extra
+// time if it was reading them.
+func (c* CPU) DMCFetch(address uint16) byte {
+  cycles := 4
+  switch {
+  case c.stalling:
+    cycles = 2
+  case c.lastWrite:
+    cycles = 3
+  case c.lastRead == 0x4016 || c.lastRead == 0x4017:
+    c.read(c.lastRead)
+  }
+  c.Stall(cycles)
+  return c.read(address)
+}
+
+// Has the CPU hand the bus over to a transfer, like OAM DMA, once the current
+// instruction is done. The transfer makes its own accesses, and clocks the
+// components connected to the CPU for every cycle they take.
+func (c* CPU) Halt(transfer func()) {
+  c.transfers = append(c.transfers, transfer)
+}
+
+// Gets the last value on the CPU's data bus.
+func (c* CPU) DataBus() byte { return c.dataBus }
+
+// Has the CPU sit out the given number of cycles once the current instruction
+// is done.
+func (c* CPU) Stall(cycles int) {
+  c.stall += cycles
+}
+
+// Gets the number of cycles elapsed since power on, including those of the
+// instruction being run.
+func (c* CPU) Cycles() uint64 {
+  return c.count + uint64(c.cycles)
+}
+
 // Gets the current CPU status flags.
 func (c* CPU) status(flag byte) bool {
   return (c.p & flag) != 0
@@ -87,7 +229,7 @@ func (c* CPU) SetZ(status bool) { c.setStatus(Z, status) }
 func (c* CPU) I() bool { return c.status(I) }
 
 // Sets the I flag
-func (c* CPU) SetI(status bool) { c.status(I) }
+func (c* CPU) SetI(status bool) { c.setStatus(I, status) }
 
 // Gets the current value of the D flag
 func (c* CPU) D() bool { return c.status(D) }
@@ -118,7 +260,7 @@ func (c* CPU) SetN(status bool) { c.setStatus(N, status) }
 // Adds a CPU cycle, and advances the program counter by one.
 func (c* CPU) getFromImmediate() byte {
   c.cycles++
-  value := c.memory.GetUint8At(c.pc)
+  value := c.read(c.pc)
   c.pc++
   return value
 }
@@ -136,7 +278,7 @@ func (c* CPU) getZeroPageAddress() uint16 {
 // Adds two CPU cycles, and advances the program counter by one.
 func (c* CPU) getFromZeroPage() byte {
   address := c.getZeroPageAddress()
-  value := c.memory.GetUint8At(address)
+  value := c.read(address)
   return value;
 }
 
@@ -150,46 +292,48 @@ func (c* CPU) getZeroPageXAddress() uint16 {
 
 // Gets the 8-bit value located at the zero-page + x address.
 func (c* CPU) getFromZeroPageX() byte {
-  return c.memory.GetUint8At(c.getZeroPageXAddress())
+  return c.read(c.getZeroPageXAddress())
 }
 
 // Gets the Zero Page,Y address.
 //
-// Adds four CPU cycles, and advances the program counter by one.
+// Adds three CPU cycles, and advances the program counter by one.
 func (c* CPU) getZeroPageYAddress() uint16 {
-  c.cycles += 3;
+  c.cycles += 2;
   return uint16(c.getFromImmediate() + c.y)
 }
 
 // Gets the 8-but value located at the zero-age + y address.
 func (c* CPU) getFromZeroPageY() byte {
-  return c.memory.GetUint8At(c.getZeroPageYAddress())
+  return c.read(c.getZeroPageYAddress())
 }
 
 // Gets the absolute address.
 //
-// Adds 
+// Adds three CPU cycles, and advances the program counter by two.
 func (c* CPU) getAbsoluteAddress() uint16 {
+  c.cycles++
   lsb := c.getFromImmediate()
   msb := c.getFromImmediate()
-  address := (uint16(msb) << 8) & uint16(lsb)
+  address := uint16(msb) << 8 | uint16(lsb)
   return address
 }
 
 // Gets the 8-bit value located at the absolute address.
 func (c* CPU) getFromAbsolute() byte {
-  return c.memory.GetUint8At(c.getAbsoluteAddress())
+  return c.read(c.getAbsoluteAddress())
 }
 
 // This gets the absolute address with an offset.
 func (c* CPU) getAbsoluteAddressWithOffset(offset byte, precompute bool) uint16 {
+  c.cycles++
   lsb := c.getFromImmediate()
   msb := c.getFromImmediate()
   if (255 - offset < lsb || !precompute) {
     // This implies that page boundary has crossed.
     c.cycles++
   }
-  address := ((uint16(msb) << 8) & uint16(lsb)) + uint16(offset)
+  address := (uint16(msb) << 8 | uint16(lsb)) + uint16(offset)
   return address;
 }
 
@@ -200,7 +344,7 @@ func (c* CPU) getAbsoluteXAddress(precompute bool) uint16 {
 
 // Gets the 8-bit value located at the absolute + X address.
 func (c* CPU) getFromAbsoluteX() byte {
-  return c.memory.GetUint8At(c.getAbsoluteXAddress(true))
+  return c.read(c.getAbsoluteXAddress(true))
 }
 
 func (c* CPU) getAbsoluteYAddress(precompute bool) uint16 {
@@ -209,48 +353,218 @@ func (c* CPU) getAbsoluteYAddress(precompute bool) uint16 {
 
 // Gets the 8-bit value located at the absolute + Y address.
 func (c* CPU) getFromAbsoluteY() byte {
-  return c.memory.GetUint8At(c.getAbsoluteAddressWithOffset(c.y, true))
+  return c.read(c.getAbsoluteAddressWithOffset(c.y, true))
 }
 
 // Gets the Indirect,X address.
-func (c* CPU) getIndirectIndexedAddress(precompute bool) uint16 {
+//
+// Adds five CPU cycles, and advances the program counter by one.
+func (c* CPU) getIndirectIndexedAddress() uint16 {
   zeroPageAddress := c.getFromImmediate() + c.x
-  lsb := c.memory.GetUint8At(uint16(zeroPageAddress))
-  msb := c.memory.GetUint8At(uint16(zeroPageAddress + 1))
-  if (!precompute || 255 - c.x < lsb) {
-    c.cycles++
-  }
-  address := uint16(msb << 8) & uint16(lsb)
+  lsb := c.read(uint16(zeroPageAddress))
+  msb := c.read(uint16(zeroPageAddress + 1))
+  c.cycles += 4
+  address := uint16(msb) << 8 | uint16(lsb)
   return address;
 }
 
 // Gets the 8-bit value located at the indirect indexed address.
 func (c* CPU) getFromIndirectIndexed() byte {
-  return c.memory.GetUint8At(c.getIndirectIndexedAddress(true))
+  return c.read(c.getIndirectIndexedAddress())
 }
 
 // Gets the (Indirect),Y address.
-func (c* CPU) getIndexedIndirectAddress() uint16 {
+//
+// Adds four CPU cycles, plus one if a page boundary gets crossed or the
+// address isn't precomputed, and advances the program counter by one.
+func (c* CPU) getIndexedIndirectAddress(precompute bool) uint16 {
   zeroPageAddress := c.getFromImmediate()
-  lsb := c.memory.GetUint8At(uint16(zeroPageAddress))
-  msb := c.memory.GetUint8At(uint16(zeroPageAddress + 1))
-  address := uint16(msb << 8) & uint16(lsb) + uint16(c.y)
+  lsb := c.read(uint16(zeroPageAddress))
+  msb := c.read(uint16(zeroPageAddress + 1))
+  c.cycles += 3
+  if (!precompute || 255 - c.y < lsb) {
+    c.cycles++
+  }
+  address := (uint16(msb) << 8 | uint16(lsb)) + uint16(c.y)
   return address
 }
 
 // Gets the 8-bit value located at the indexed indirect address.
 func (c* CPU) getFromIndexedIndirect() byte {
-  return c.memory.GetUint8At(c.getIndexedIndirectAddress())
+  return c.read(c.getIndexedIndirectAddress(true))
+}
+
+// Gets the address a branch goes to, from the offset after the opcode.
+//
+// Adds a CPU cycle, and advances the program counter by one.
+func (c* CPU) getRelativeAddress() uint16 {
+  offset := int8(c.getFromImmediate())
+  return uint16(int(c.pc) + int(offset))
 }
 
 func isNegative(value byte) bool {
   return value & 0x80 != 0
 }
 
+// Sets the Z and N flags from a value.
+func (c* CPU) setZN(value byte) {
+  c.SetZ(value == 0)
+  c.SetN(isNegative(value))
+}
+
 // ADd with Carry
+//
+// The 2A03 has no decimal mode, so the D flag is ignored.
 func (c* CPU) adc(value byte) {
   var carry byte = 0; if (c.C()) { carry = 1 }
-  c.a = value + c.a + carry
+  sum := uint16(c.a) + uint16(value) + uint16(carry)
+  result := byte(sum)
+  c.SetC(sum > 0xFF)
+  // Overflow is when both operands have the same sign, and the result doesn't.
+  c.SetV((c.a ^ result) & (value ^ result) & 0x80 != 0)
+  c.a = result
+  c.setZN(result)
+}
+
+// SuBtract with Carry, which is adding the one's complement.
+func (c* CPU) sbc(value byte) {
+  c.adc(^value)
+}
+
+// logical AND
+func (c* CPU) and(value byte) {
+  c.a &= value
+  c.setZN(c.a)
+}
+
+// logical inclusive OR with A
+func (c* CPU) ora(value byte) {
+  c.a |= value
+  c.setZN(c.a)
+}
+
+// Exclusive OR
+func (c* CPU) eor(value byte) {
+  c.a ^= value
+  c.setZN(c.a)
+}
+
+// BIT test
+func (c* CPU) bit(value byte) {
+  c.SetZ(c.a & value == 0)
+  c.SetV(value & 0x40 != 0)
+  c.SetN(isNegative(value))
+}
+
+// Compares a register with a value, the way CMP, CPX and CPY do.
+func (c* CPU) compare(register byte, value byte) {
+  c.SetC(register >= value)
+  c.setZN(register - value)
+}
+
+// CoMPare with the accumulator
+func (c* CPU) cmp(value byte) {
+  c.compare(c.a, value)
+}
+
+// Arithmetic Shift Left
+func (c* CPU) asl(value byte) byte {
+  c.SetC(value & 0x80 != 0)
+  value <<= 1
+  c.setZN(value)
+  return value
+}
+
+// Logical Shift Right
+func (c* CPU) lsr(value byte) byte {
+  c.SetC(value & 0x01 != 0)
+  value >>= 1
+  c.setZN(value)
+  return value
+}
+
+// ROtate Left
+func (c* CPU) rol(value byte) byte {
+  var carry byte = 0; if (c.C()) { carry = 1 }
+  c.SetC(value & 0x80 != 0)
+  value = value << 1 | carry
+  c.setZN(value)
+  return value
+}
+
+// ROtate Right
+func (c* CPU) ror(value byte) byte {
+  var carry byte = 0; if (c.C()) { carry = 0x80 }
+  c.SetC(value & 0x01 != 0)
+  value = value >> 1 | carry
+  c.setZN(value)
+  return value
+}
+
+// INCrement
+func (c* CPU) inc(value byte) byte {
+  value++
+  c.setZN(value)
+  return value
+}
+
+// DECrement
+func (c* CPU) dec(value byte) byte {
+  value--
+  c.setZN(value)
+  return value
+}
+
+// Runs a read-modify-write instruction on memory. Like the hardware, it writes
+// the value it read back before writing the result.
+//
+// Adds two CPU cycles.
+func (c* CPU) modify(address uint16, operation func(value byte) byte) {
+  value := c.read(address)
+  c.write(address, value)
+  c.write(address, operation(value))
+  c.cycles += 2
+}
+
+// Runs a read-modify-write instruction on the accumulator.
+//
+// Adds a CPU cycle.
+func (c* CPU) modifyA(operation func(value byte) byte) {
+  c.a = operation(c.a)
+  c.cycles++
+}
+
+// Moves a value into a register, setting the Z and N flags from it, the way
+// the transfer instructions do.
+//
+// Adds a CPU cycle.
+func (c* CPU) transfer(value byte, register *byte) {
+  *register = value
+  c.setZN(value)
+  c.cycles++
+}
+
+// Sets or clears one of the flags.
+//
+// Adds a CPU cycle.
+func (c* CPU) flag(flag byte, status bool) {
+  c.setStatus(flag, status)
+  c.cycles++
+}
+
+// Branches to the given address if the condition holds.
+//
+// Adds a CPU cycle if the branch is taken, and another if it goes to a
+// different page.
+func (c* CPU) branch(condition bool, address uint16) {
+  if !condition {
+    return
+  }
+  c.cycles++
+  if address & 0xFF00 != c.pc & 0xFF00 {
+    c.cycles++
+  }
+  c.pc = address
 }
 
 // LoaD Accumulator
@@ -280,12 +594,106 @@ func (c *CPU) nop() {
 
 // STore Accumulator
 func (c* CPU) sta(address uint16) {
-  c.memory.SetUint8At(address, c.A())
+  c.write(address, c.A())
 }
 
 // STore X register
 func (c* CPU) stx(address uint16) {
-  c.memory.SetUint8At(address, c.x)
+  c.write(address, c.x)
+}
+
+// STore Y register
+func (c* CPU) sty(address uint16) {
+  c.write(address, c.y)
+}
+
+// JuMP to the absolute address.
+func (c* CPU) jmp() {
+  lsb := c.getFromImmediate()
+  msb := c.getFromImmediate()
+  c.pc = uint16(msb) << 8 | uint16(lsb)
+}
+
+// JuMP to the address pointed to. The pointer never crosses a page: if it's at
+// $xxFF, the high byte comes from $xx00.
+func (c* CPU) jmpIndirect() {
+  pointer := c.getAbsoluteAddress()
+  lsb := c.read(pointer)
+  msb := c.read(pointer & 0xFF00 | uint16(byte(pointer) + 1))
+  c.pc = uint16(msb) << 8 | uint16(lsb)
+  c.cycles++
+}
+
+// Jump to SubRoutine, pushing the address of the last byte of the instruction.
+func (c* CPU) jsr() {
+  lsb := c.getFromImmediate()
+  c.push(byte(c.pc >> 8))
+  c.push(byte(c.pc))
+  msb := c.getFromImmediate()
+  c.pc = uint16(msb) << 8 | uint16(lsb)
+  c.cycles += 3
+}
+
+// ReTurn from Interrupt
+func (c* CPU) rti() {
+  c.plp()
+  lsb := c.pull()
+  msb := c.pull()
+  c.pc = uint16(msb) << 8 | uint16(lsb)
+  c.cycles += 2
+}
+
+// force interrupt (BReaK). The byte after the opcode gets skipped, and the
+// status gets pushed with the B flag set.
+func (c* CPU) brk() {
+  c.pc++
+  c.push(byte(c.pc >> 8))
+  c.push(byte(c.pc))
+  c.push(c.p | B | 0x20)
+  c.setStatus(I, true)
+  c.pc = c.readUint16LE(0xFFFE)
+  c.cycles += 6
+}
+
+// Transfer X register to Stack pointer, which is the one transfer that leaves
+// the flags alone.
+func (c* CPU) txs() {
+  c.sp = c.x
+  c.cycles++
+}
+
+// PusH Accumulator
+func (c* CPU) pha() {
+  c.push(c.a)
+  c.cycles += 2
+}
+
+// PusH Processor status, with the B flag set.
+func (c* CPU) php() {
+  c.push(c.p | B | 0x20)
+  c.cycles += 2
+}
+
+// PuLl Accumulator
+func (c* CPU) pla() {
+  c.a = c.pull()
+  c.setZN(c.a)
+  c.cycles += 3
+}
+
+// PuLl Processor status. The B flag and bit 5 aren't really there, so they're
+// left as they were.
+func (c* CPU) plp() {
+  c.p = c.pull() & ^(B | 0x20) | c.p & (B | 0x20)
+  c.cycles += 3
+}
+
+// ReTurn from Subroutine
+func (c* CPU) rts() {
+  lsb := c.pull()
+  msb := c.pull()
+  c.pc = (uint16(msb) << 8 | uint16(lsb)) + 1
+  c.cycles += 5
 }
 
 // Simply runs the next instruction. Will write to registers and memory.
@@ -313,88 +721,125 @@ func (c* CPU) RunNextInstruction() error {
   case 0x71: c.adc(c.getFromIndexedIndirect())
 
   // AND (Logical AND)
-  // TODO: implement AND
+  case 0x29: c.and(c.getFromImmediate())
+  case 0x25: c.and(c.getFromZeroPage())
+  case 0x35: c.and(c.getFromZeroPageX())
+  case 0x2D: c.and(c.getFromAbsolute())
+  case 0x3D: c.and(c.getFromAbsoluteX())
+  case 0x39: c.and(c.getFromAbsoluteY())
+  case 0x21: c.and(c.getFromIndirectIndexed())
+  case 0x31: c.and(c.getFromIndexedIndirect())
 
   // ASL (Arithmetic Shift Left)
-  // TODO: implement ASL
+  case 0x0A: c.modifyA(c.asl)
+  case 0x06: c.modify(c.getZeroPageAddress(), c.asl)
+  case 0x16: c.modify(c.getZeroPageXAddress(), c.asl)
+  case 0x0E: c.modify(c.getAbsoluteAddress(), c.asl)
+  case 0x1E: c.modify(c.getAbsoluteXAddress(false), c.asl)
 
   // BCC (Branch if Carry Clear)
-  // TODO: implement BCC
+  case 0x90: c.branch(!c.C(), c.getRelativeAddress())
 
   // BCS (Branch if Carry Set)
-  // TODO: implement BCS
+  case 0xB0: c.branch(c.C(), c.getRelativeAddress())
 
   // BEQ (Branch if EQual)
-  // TODO: implement BEQ
+  case 0xF0: c.branch(c.Z(), c.getRelativeAddress())
 
   // BIT (BIT test)
-  // TODO: implement BIT
+  case 0x24: c.bit(c.getFromZeroPage())
+  case 0x2C: c.bit(c.getFromAbsolute())
 
   // BMI (Branch if MInus)
-  // TODO: implement BMI
+  case 0x30: c.branch(c.N(), c.getRelativeAddress())
 
   // BNE (Branch if Not Equal)
-  // TODO: impelement BNE
+  case 0xD0: c.branch(!c.Z(), c.getRelativeAddress())
 
   // BPL (Branch if positive (PLus))
-  // TODO: implement BPL
+  case 0x10: c.branch(!c.N(), c.getRelativeAddress())
 
   // BRK (force interrupt (BReaK))
-  // TODO: implement BRK
+  case 0x00: c.brk()
 
   // BVC (Branch if oVerflow Clear)
-  // TODO: implement BVC
+  case 0x50: c.branch(!c.V(), c.getRelativeAddress())
 
   // BVS (Branch if oVerflow Set)
-  // TODO: implement BVS
+  case 0x70: c.branch(c.V(), c.getRelativeAddress())
 
   // CLC (CLear Carry flag)
-  // TODO: implement CLC
+  case 0x18: c.flag(C, false)
 
   // CLD (CLear Decimal mode)
-  // TODO: implement CLD
+  case 0xD8: c.flag(D, false)
 
   // CLI (CLear Interrupt Disable)
-  // TODO: implement CLI
+  case 0x58: c.flag(I, false)
 
   // CLV (CLear oVerflow flag)
-  // TODO: implement CLV
+  case 0xB8: c.flag(V, false)
 
   // CMP (CoMPare)
-  // TODO: implement CMP
+  case 0xC9: c.cmp(c.getFromImmediate())
+  case 0xC5: c.cmp(c.getFromZeroPage())
+  case 0xD5: c.cmp(c.getFromZeroPageX())
+  case 0xCD: c.cmp(c.getFromAbsolute())
+  case 0xDD: c.cmp(c.getFromAbsoluteX())
+  case 0xD9: c.cmp(c.getFromAbsoluteY())
+  case 0xC1: c.cmp(c.getFromIndirectIndexed())
+  case 0xD1: c.cmp(c.getFromIndexedIndirect())
 
   // CPX (ComPare X)
-  // TODO: implement CPX
+  case 0xE0: c.compare(c.x, c.getFromImmediate())
+  case 0xE4: c.compare(c.x, c.getFromZeroPage())
+  case 0xEC: c.compare(c.x, c.getFromAbsolute())
 
   // CPY (ComPare Y)
-  // TODO: implement CPY
+  case 0xC0: c.compare(c.y, c.getFromImmediate())
+  case 0xC4: c.compare(c.y, c.getFromZeroPage())
+  case 0xCC: c.compare(c.y, c.getFromAbsolute())
 
   // DEC (DECrement memory)
-  // TODO: implement DEC
+  case 0xC6: c.modify(c.getZeroPageAddress(), c.dec)
+  case 0xD6: c.modify(c.getZeroPageXAddress(), c.dec)
+  case 0xCE: c.modify(c.getAbsoluteAddress(), c.dec)
+  case 0xDE: c.modify(c.getAbsoluteXAddress(false), c.dec)
 
   // DEX (DEcrement X register)
-  // TODO: implement DEX
+  case 0xCA: c.transfer(c.x - 1, &c.x)
 
   // DEY (DEcrement Y register)
-  // TODO: implement DEY
+  case 0x88: c.transfer(c.y - 1, &c.y)
 
   // EOR (Exclosive OR)
-  // TODO: implement EOR
+  case 0x49: c.eor(c.getFromImmediate())
+  case 0x45: c.eor(c.getFromZeroPage())
+  case 0x55: c.eor(c.getFromZeroPageX())
+  case 0x4D: c.eor(c.getFromAbsolute())
+  case 0x5D: c.eor(c.getFromAbsoluteX())
+  case 0x59: c.eor(c.getFromAbsoluteY())
+  case 0x41: c.eor(c.getFromIndirectIndexed())
+  case 0x51: c.eor(c.getFromIndexedIndirect())
 
   // INC (INCrement memory)
-  // TODO: implement INC
+  case 0xE6: c.modify(c.getZeroPageAddress(), c.inc)
+  case 0xF6: c.modify(c.getZeroPageXAddress(), c.inc)
+  case 0xEE: c.modify(c.getAbsoluteAddress(), c.inc)
+  case 0xFE: c.modify(c.getAbsoluteXAddress(false), c.inc)
 
   // INX (INcrement X register)
-  // TODO: implement INX
+  case 0xE8: c.transfer(c.x + 1, &c.x)
 
   // INY (INcrement Y register)
-  // TODO: implement INY
+  case 0xC8: c.transfer(c.y + 1, &c.y)
 
   // JMP (JuMP)
-  // TODO: implement JMP
+  case 0x4C: c.jmp()
+  case 0x6C: c.jmpIndirect()
 
   // JSR (Jump to SubRoutine)
-  // TODO: implement JSR
+  case 0x20: c.jsr()
 
   // LDA (LoaD Accumulator)
   case 0xA9: c.lda(c.getFromImmediate())
@@ -426,51 +871,79 @@ func (c* CPU) RunNextInstruction() error {
   case 0xA0: c.ldy(c.getFromImmediate())
   case 0xA4: c.ldy(c.getFromZeroPage())
   case 0xB4: c.ldy(c.getFromZeroPageX())
+  case 0xAC: c.ldy(c.getFromAbsolute())
+  case 0xBC: c.ldy(c.getFromAbsoluteX())
 
   // LSR (Logical Shift Right)
-  // TODO: implement LSR
+  case 0x4A: c.modifyA(c.lsr)
+  case 0x46: c.modify(c.getZeroPageAddress(), c.lsr)
+  case 0x56: c.modify(c.getZeroPageXAddress(), c.lsr)
+  case 0x4E: c.modify(c.getAbsoluteAddress(), c.lsr)
+  case 0x5E: c.modify(c.getAbsoluteXAddress(false), c.lsr)
 
   // NOP (NO oPeration)
   case 0xEA: c.nop()
 
   // ORA (logical inclusive OR with A)
-  // TODO: implement ORA
+  case 0x09: c.ora(c.getFromImmediate())
+  case 0x05: c.ora(c.getFromZeroPage())
+  case 0x15: c.ora(c.getFromZeroPageX())
+  case 0x0D: c.ora(c.getFromAbsolute())
+  case 0x1D: c.ora(c.getFromAbsoluteX())
+  case 0x19: c.ora(c.getFromAbsoluteY())
+  case 0x01: c.ora(c.getFromIndirectIndexed())
+  case 0x11: c.ora(c.getFromIndexedIndirect())
 
   // PHA (PusH Accumulator)
-  // TODO: implement PHA
+  case 0x48: c.pha()
 
   // PHP (PusH Processor status)
-  // TODO: implement PHP
+  case 0x08: c.php()
 
   // PLA (PuLl Accumulator)
-  // TODO: implement PLA
+  case 0x68: c.pla()
 
   // PLP (PuLl Processor status)
-  // TODO: implement PLP
+  case 0x28: c.plp()
 
   // ROL (ROtate Left)
-  // TODO: implement ROL
+  case 0x2A: c.modifyA(c.rol)
+  case 0x26: c.modify(c.getZeroPageAddress(), c.rol)
+  case 0x36: c.modify(c.getZeroPageXAddress(), c.rol)
+  case 0x2E: c.modify(c.getAbsoluteAddress(), c.rol)
+  case 0x3E: c.modify(c.getAbsoluteXAddress(false), c.rol)
 
   // ROR (ROtate Right)
-  // TODO: implement ROR
+  case 0x6A: c.modifyA(c.ror)
+  case 0x66: c.modify(c.getZeroPageAddress(), c.ror)
+  case 0x76: c.modify(c.getZeroPageXAddress(), c.ror)
+  case 0x6E: c.modify(c.getAbsoluteAddress(), c.ror)
+  case 0x7E: c.modify(c.getAbsoluteXAddress(false), c.ror)
 
   // RTI (ReTurn from Interrupt)
-  // TODO: implement RTI
+  case 0x40: c.rti()
 
   // RTS (ReTurn from Subroutine)
-  // TODO: implement RTS
+  case 0x60: c.rts()
 
   // SBC (SuBtract with Carry)
-  // TODO: implement SBC
+  case 0xE9: c.sbc(c.getFromImmediate())
+  case 0xE5: c.sbc(c.getFromZeroPage())
+  case 0xF5: c.sbc(c.getFromZeroPageX())
+  case 0xED: c.sbc(c.getFromAbsolute())
+  case 0xFD: c.sbc(c.getFromAbsoluteX())
+  case 0xF9: c.sbc(c.getFromAbsoluteY())
+  case 0xE1: c.sbc(c.getFromIndirectIndexed())
+  case 0xF1: c.sbc(c.getFromIndexedIndirect())
 
   // SEC (SEt Carry)
-  // TODO: implement SEC
+  case 0x38: c.flag(C, true)
 
   // SED (SEt Decimal flag)
-  // TODO: implement SED
+  case 0xF8: c.flag(D, true)
 
   // SEI (SEt Interrupt disable)
-  // TODO: implement SEI
+  case 0x78: c.flag(I, true)
 
   // STA (STore Accumulator)
   // TODO: test this
@@ -484,35 +957,37 @@ func (c* CPU) RunNextInstruction() error {
   // TODO: test this
   case 0x99: c.sta(c.getAbsoluteYAddress(false))
   // TODO: test this
-  case 0x81: c.sta(c.getIndexedIndirectAddress())
+  case 0x81: c.sta(c.getIndirectIndexedAddress())
   // TODO: test this
-  case 0x90: c.sta(c.getIndirectIndexedAddress(false))
+  case 0x91: c.sta(c.getIndexedIndirectAddress(false))
 
   // STX (STore X register)
   case 0x86: c.stx(c.getZeroPageAddress())
-  // TODO: implement Zero Page,Y
-  // TODO: implement Absolute
+  case 0x96: c.stx(c.getZeroPageYAddress())
+  case 0x8E: c.stx(c.getAbsoluteAddress())
 
   // STY (STore Y register)
-  // TODO: implement STY
+  case 0x84: c.sty(c.getZeroPageAddress())
+  case 0x94: c.sty(c.getZeroPageXAddress())
+  case 0x8C: c.sty(c.getAbsoluteAddress())
 
   // TAX (Transfer Accumulator to X register)
-  // TODO: implement TAX
+  case 0xAA: c.transfer(c.a, &c.x)
 
   // TAY (Transfer Accumulator to Y register)
-  // TODO: implement TAY
+  case 0xA8: c.transfer(c.a, &c.y)
 
   // TSX (Transfer Stack Pointer to X)
-  // TODO: implement TSX
+  case 0xBA: c.transfer(c.sp, &c.x)
 
   // TXA (Transfer register X to Accumulator)
-  // TODO: implement TXA
+  case 0x8A: c.transfer(c.x, &c.a)
 
   // TXS (Transfer X register to Stack pointer)
-  // TODO: implement TXS
+  case 0x9A: c.txs()
 
   // TYA (Transfer Y register to Accumulator)
-  // TODO: implement TYA
+  case 0x98: c.transfer(c.y, &c.a)
   }
 
   return nil
@@ -521,7 +996,127 @@ func (c* CPU) RunNextInstruction() error {
 // Have the program counter point to the location represented by the 16-bit LE
 // values located at addresses 0xFFFC
 func (c* CPU) MovePCToResetVector() {
-  c.pc = c.memory.GetUint16LEAt(0xFFFC)
+  c.pc = c.readUint16LE(0xFFFC)
+}
+
+// Resets the CPU, the way the reset button does. It goes through the motions
+// of an interrupt, with reads of the stack in place of the writes, so the
+// registers are kept, the stack pointer goes down by 3, and it jumps to the
+// handler pointed to by the reset vector.
+//
+// The components connected to the CPU get clocked for the 7 cycles it takes,
+// as they do for the instructions run by Step.
+func (c* CPU) Reset() {
+  c.nmiPending = false
+  c.stall = 0
+  c.running = true
+  c.accesses = 0
+  for i := 0; i < 3; i++ {
+    c.read(0x0100 | uint16(c.sp))
+    c.sp--
+  }
+  c.setStatus(I, true)
+  c.pc = c.readUint16LE(0xFFFC)
+  c.cycles += 7
+  c.running = false
+
+  for c.cycles > 0 {
+    c.cycles--
+    c.clock()
+  }
+}
+
+// Runs a single CPU cycle's worth of the components connected to the CPU, and
+// samples the interrupt lines at the end of it.
+func (c* CPU) clock() {
+  c.count++
+  for _, component := range c.clocked {
+    component.Clock()
+  }
+
+  // NMI is edge-sensitive, so it's only the line going from high to low that
+  // counts.
+  line := c.nmiSource != nil && c.nmiSource.NMI()
+  if line && !c.nmiLine {
+    c.nmiPending = true
+  }
+  c.nmiLine = line
+}
+
+// Pushes a byte onto the stack.
+func (c* CPU) push(value byte) {
+  c.write(0x0100 | uint16(c.sp), value)
+  c.sp--
+}
+
+// Pulls a byte off the stack.
+func (c* CPU) pull() byte {
+  c.sp++
+  return c.read(0x0100 | uint16(c.sp))
+}
+
+// Pushes the program counter and status onto the stack, and jumps to the
+// handler pointed to by the given vector.
+func (c* CPU) interrupt(vector uint16) {
+  c.push(byte(c.pc >> 8))
+  c.push(byte(c.pc))
+  c.push(c.p & ^B | 0x20)
+  c.setStatus(I, true)
+  c.pc = c.readUint16LE(vector)
+  c.cycles += 7
+}
+
+// Clocks the components connected to the CPU up to the cycle of the bus access
+// that's about to be made, which is one cycle for every access before it.
+//
+// That way, writes to the PPU in the middle of an instruction land on the dot
+// they would on hardware. Any cycles in which the CPU doesn't touch the bus
+// get made up once the instruction is done.
+func (c* CPU) catchUp() {
+  if !c.running {
+    return
+  }
+  if c.accesses > 0 {
+    c.cycles--
+    c.clock()
+  }
+  c.accesses++
+}
+
+// Runs the next instruction, or services a pending interrupt, clocking the
+// components connected to the CPU as it goes, then clocks them for the rest of
+// the cycles that took. After that come any transfers the CPU got halted for,
+// and any cycles it got stalled for.
+func (c* CPU) Step() error {
+  var err error
+  c.running = true
+  c.accesses = 0
+  if c.nmiPending {
+    c.nmiPending = false
+    c.interrupt(0xFFFA)
+  } else if !c.I() && c.irq() {
+    c.interrupt(0xFFFE)
+  } else {
+    err = c.RunNextInstruction()
+  }
+  c.running = false
+
+  for c.cycles > 0 {
+    c.cycles--
+    c.clock()
+  }
+  c.stalling = true
+  for len(c.transfers) > 0 {
+    transfer := c.transfers[0]
+    c.transfers = c.transfers[1:]
+    transfer()
+  }
+  for c.stall > 0 {
+    c.stall--
+    c.clock()
+  }
+  c.stalling = false
+  return err
 }
 
 // Starts the program in memory.
@@ -529,10 +1124,7 @@ func (c* CPU) Run() int {
   c.MovePCToResetVector()
 
   for {
-    c.RunNextInstruction()
-    for c.cycles > 0 {
-      c.cycles--
-    }
+    c.Step()
   }
 
   return 0
@@ -5,6 +5,7 @@ import "log"
 
 func testStatus(t *testing.T, flag byte) {
   cpu := CPUNew()
+  cpu.p = 0
   if (cpu.status(flag)) {
     t.Fail()
   }
@@ -89,7 +90,326 @@ func TestMovePCToResetVector(t *testing.T) {
 ////////////////////////////////////////////////////////////////////////////////
 
 func TestAdc(t *testing.T) {
-  
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xA9, 0x50, // LDA #$50
+    0x69, 0x50, // ADC #$50
+    0x69, 0x60, // ADC #$60
+    0x69, 0x00, // ADC #$00
+  })
+  cpu.RunNextInstruction(); cpu.cycles = 0
+
+  cpu.RunNextInstruction()
+  if cpu.A() != 0xA0 || cpu.C() || !cpu.V() || !cpu.N() || cpu.Z() {
+    log.Printf("Expecting 50 + 50 to overflow into A0, but got %X and P = %X", cpu.A(), cpu.P())
+    t.Fail()
+  }
+  if cpu.cycles != 2 {
+    log.Printf("Expecting 2 CPU cycles, but got %d", cpu.cycles)
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x00 || !cpu.C() || cpu.V() || !cpu.Z() {
+    log.Printf("Expecting A0 + 60 to carry out to 0, but got %X and P = %X", cpu.A(), cpu.P())
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x01 || cpu.C() {
+    log.Printf("Expecting the carry to be added in, but got %X", cpu.A())
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestSbc(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0x38,       // SEC
+    0xA9, 0x50, // LDA #$50
+    0xE9, 0xF0, // SBC #$F0
+    0xE9, 0x5F, // SBC #$5F
+  })
+  cpu.RunNextInstruction()
+  if !cpu.C() || cpu.cycles != 2 {
+    log.Printf("Expecting SEC to set the carry in 2 cycles")
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x60 || cpu.C() || cpu.V() {
+    log.Printf("Expecting 50 - F0 to borrow into 60, but got %X and P = %X", cpu.A(), cpu.P())
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x00 || !cpu.C() || !cpu.Z() {
+    log.Printf("Expecting 60 - 5F - 1 to be 0, but got %X and P = %X", cpu.A(), cpu.P())
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestLogicalOperations(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xA9, 0xF0, // LDA #$F0
+    0x29, 0x3C, // AND #$3C
+    0x09, 0x81, // ORA #$81
+    0x49, 0xFF, // EOR #$FF
+    0x85, 0x10, // STA $10
+    0x24, 0x10, // BIT $10
+  })
+  cpu.RunNextInstruction()
+  for _, expected := range []byte{0x30, 0xB1, 0x4E} {
+    cpu.RunNextInstruction()
+    if cpu.A() != expected || cpu.N() != isNegative(expected) {
+      log.Printf("Expecting A to be %X, but got %X", expected, cpu.A())
+      t.Fail()
+    }
+  }
+  cpu.RunNextInstruction(); cpu.cycles = 0
+  cpu.RunNextInstruction()
+  if cpu.Z() || !cpu.V() || cpu.N() || cpu.cycles != 3 {
+    log.Printf("Expecting BIT to copy bits 6 and 7 in 3 cycles, but got P = %X", cpu.P())
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestShifts(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xA9, 0x81, // LDA #$81
+    0x0A,       // ASL A
+    0x2A,       // ROL A
+    0x85, 0x10, // STA $10
+    0x46, 0x10, // LSR $10
+    0x6E, 0x10, 0x00, // ROR $0010
+  })
+  cpu.RunNextInstruction(); cpu.cycles = 0
+
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x02 || !cpu.C() || cpu.cycles != 2 {
+    log.Printf("Expecting ASL to shift 81 into 02 and the carry, but got %X", cpu.A())
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x05 || cpu.C() {
+    log.Printf("Expecting ROL to rotate the carry in, but got %X", cpu.A())
+    t.Fail()
+  }
+  cpu.RunNextInstruction(); cpu.cycles = 0
+
+  cpu.RunNextInstruction()
+  if cpu.memory.GetUint8At(0x10) != 0x02 || !cpu.C() || cpu.cycles != 5 {
+    log.Printf("Expecting LSR to shift 05 into 02 in 5 cycles, but got %X in %d", cpu.memory.GetUint8At(0x10), cpu.cycles)
+    t.Fail()
+  }
+  cpu.cycles = 0
+  cpu.RunNextInstruction()
+  if cpu.memory.GetUint8At(0x10) != 0x81 || cpu.C() || !cpu.N() || cpu.cycles != 6 {
+    log.Printf("Expecting ROR to rotate 02 into 81 in 6 cycles, but got %X in %d", cpu.memory.GetUint8At(0x10), cpu.cycles)
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestCompare(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xA9, 0x40, // LDA #$40
+    0xC9, 0x40, // CMP #$40
+    0xA2, 0x10, // LDX #$10
+    0xE0, 0x20, // CPX #$20
+    0xA0, 0x30, // LDY #$30
+    0xC0, 0x20, // CPY #$20
+  })
+  expected := []struct{ c, z, n bool }{
+    {true, true, false},
+    {false, false, true},
+    {true, false, false},
+  }
+  for _, flags := range expected {
+    cpu.RunNextInstruction()
+    cpu.RunNextInstruction()
+    if cpu.C() != flags.c || cpu.Z() != flags.z || cpu.N() != flags.n {
+      log.Printf("Expecting C = %t, Z = %t and N = %t, but got P = %X", flags.c, flags.z, flags.n, cpu.P())
+      t.Fail()
+    }
+  }
+  cpu.cycles = 0
+}
+
+func TestIncrementAndDecrement(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xE6, 0x10, // INC $10
+    0xC6, 0x11, // DEC $11
+    0xA2, 0xFF, // LDX #$FF
+    0xE8,       // INX
+    0x88,       // DEY
+  })
+  cpu.RunNextInstruction()
+  if cpu.memory.GetUint8At(0x10) != 0x01 || cpu.cycles != 5 {
+    log.Printf("Expecting INC to take 5 cycles, but got %X in %d", cpu.memory.GetUint8At(0x10), cpu.cycles)
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.memory.GetUint8At(0x11) != 0xFF || !cpu.N() {
+    log.Printf("Expecting DEC to wrap around to FF, but got %X", cpu.memory.GetUint8At(0x11))
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  cpu.RunNextInstruction()
+  if cpu.X() != 0x00 || !cpu.Z() {
+    log.Printf("Expecting INX to wrap around to 0, but got %X", cpu.X())
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.Y() != 0xFF || !cpu.N() {
+    log.Printf("Expecting DEY to wrap around to FF, but got %X", cpu.Y())
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestBranches(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xA2, 0x03, // LDX #$03
+    0xCA,       // DEX
+    0xD0, 0xFD, // BNE -3
+    0xF0, 0x7F, // BEQ +127
+  })
+  cpu.RunNextInstruction()
+  cpu.RunNextInstruction(); cpu.cycles = 0
+
+  cpu.RunNextInstruction()
+  if cpu.pc != 0x8002 || cpu.cycles != 3 {
+    log.Printf("Expecting a taken branch to take 3 cycles, but got PC = %X in %d", cpu.pc, cpu.cycles)
+    t.Fail()
+  }
+  for i := 0; i < 3; i++ {
+    cpu.RunNextInstruction()
+  }
+  cpu.RunNextInstruction(); cpu.cycles = 0
+  if cpu.X() != 0 || cpu.pc != 0x8005 {
+    log.Printf("Expecting the loop to run X down to 0, but got X = %X and PC = %X", cpu.X(), cpu.pc)
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.pc != 0x8086 || cpu.cycles != 3 {
+    log.Printf("Expecting BEQ to go to 8086, but got %X in %d", cpu.pc, cpu.cycles)
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestJumps(t *testing.T) {
+  program := make([]byte, 0x200)
+  copy(program, []byte{
+    0x20, 0x00, 0x81, // JSR $8100
+    0x6C, 0xFF, 0x10, // JMP ($10FF)
+  })
+  copy(program[0x100:], []byte{
+    0xE8,             // INX
+    0x60,             // RTS
+  })
+  cpu := initCPUWithBasicInstructions(program)
+  cpu.memory.SetUint8At(0x10FF, 0x00)
+  cpu.memory.SetUint8At(0x1000, 0x82)
+  cpu.memory.SetUint8At(0x1100, 0x90)
+  sp := cpu.sp
+
+  cpu.RunNextInstruction()
+  if cpu.pc != 0x8100 || cpu.cycles != 6 || cpu.sp != sp - 2 {
+    log.Printf("Expecting JSR to take 6 cycles to get to 8100, but got %X in %d", cpu.pc, cpu.cycles)
+    t.Fail()
+  }
+  if cpu.memory.GetUint8At(0x0100 | uint16(sp)) != 0x80 || cpu.memory.GetUint8At(0x0100 | uint16(sp - 1)) != 0x02 {
+    log.Printf("Expecting JSR to push 8002")
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  cpu.RunNextInstruction()
+  if cpu.pc != 0x8003 || cpu.sp != sp || cpu.X() != 1 {
+    log.Printf("Expecting RTS to return to 8003, but got %X", cpu.pc)
+    t.Fail()
+  }
+  cpu.cycles = 0
+  // The pointer's high byte comes from $1000, not $1100.
+  cpu.RunNextInstruction()
+  if cpu.pc != 0x8200 || cpu.cycles != 5 {
+    log.Printf("Expecting JMP to go to 8200 in 5 cycles, but got %X in %d", cpu.pc, cpu.cycles)
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestStackOperations(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xA9, 0x00, // LDA #$00
+    0x08,       // PHP
+    0x48,       // PHA
+    0xA9, 0x42, // LDA #$42
+    0x68,       // PLA
+    0x28,       // PLP
+    0xA2, 0x80, // LDX #$80
+    0x9A,       // TXS
+    0xBA,       // TSX
+  })
+  cpu.RunNextInstruction()
+  p := cpu.P()
+  sp := cpu.sp
+
+  cpu.cycles = 0
+  cpu.RunNextInstruction()
+  if cpu.cycles != 3 || cpu.memory.GetUint8At(0x0100 | uint16(sp)) != p | B | 0x20 {
+    log.Printf("Expecting PHP to push P with B set in 3 cycles")
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  cpu.RunNextInstruction(); cpu.cycles = 0
+  cpu.RunNextInstruction()
+  if cpu.A() != 0x00 || !cpu.Z() || cpu.cycles != 4 {
+    log.Printf("Expecting PLA to pull 0 in 4 cycles, but got %X in %d", cpu.A(), cpu.cycles)
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.P() != p || cpu.sp != sp {
+    log.Printf("Expecting PLP to restore P = %X, but got %X", p, cpu.P())
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  cpu.RunNextInstruction()
+  if cpu.sp != 0x80 || !cpu.N() {
+    log.Printf("Expecting TXS to leave the flags alone")
+    t.Fail()
+  }
+  cpu.RunNextInstruction()
+  if cpu.X() != 0x80 || !cpu.N() {
+    log.Printf("Expecting TSX to get the stack pointer")
+    t.Fail()
+  }
+  cpu.cycles = 0
+}
+
+func TestInterruptInstructions(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0x00, 0x00, // BRK
+  })
+  cpu.SetI(false)
+  cpu.SetC(true)
+  sp := cpu.sp
+  cpu.RunNextInstruction()
+  if cpu.cycles != 7 || !cpu.I() || cpu.memory.GetUint8At(0x0100 | uint16(sp - 2)) & (B | C) != B | C {
+    log.Printf("Expecting BRK to push P with B set in 7 cycles")
+    t.Fail()
+  }
+
+  // Have the handler return right away.
+  cpu.pc = 0x0300
+  cpu.memory.SetUint8At(0x0300, 0x40) // RTI
+  cpu.cycles = 0
+  cpu.RunNextInstruction()
+  if cpu.pc != 0x8002 || cpu.I() || !cpu.C() || cpu.cycles != 6 {
+    log.Printf("Expecting RTI to return to 8002 in 6 cycles, but got %X and P = %X", cpu.pc, cpu.P())
+    t.Fail()
+  }
+  cpu.cycles = 0
 }
 
 func TestLda(t *testing.T) {
@@ -435,4 +755,60 @@ func xTestStx(t *testing.T) {
   }
   if cpu.X() != 42 { t.Fail() }
   cpu.cycles = 0
-}
\ No newline at end of file
+}
+
+func TestPowerOnState(t *testing.T) {
+  cpu := CPUNew()
+  if cpu.sp != 0xFD || cpu.p != 0x34 || !cpu.I() {
+    log.Printf("Expecting SP = FD and P = 34, but got %X and %X", cpu.sp, cpu.p)
+    t.Fail()
+  }
+  cpu.SetI(false)
+  if cpu.I() {
+    log.Printf("Expecting the interrupt disable flag to be cleared")
+    t.Fail()
+  }
+}
+
+func TestInitRAM(t *testing.T) {
+  cpu := CPUNew()
+  cpu.memory[0x0800] = 0x42
+
+  cpu.InitRAM(RAM_INIT_FF, 0)
+  if cpu.memory[0x0000] != 0xFF || cpu.memory[0x07FF] != 0xFF {
+    log.Printf("Expecting RAM to be filled with FF")
+    t.Fail()
+  }
+  if cpu.memory[0x0800] != 0x42 {
+    log.Printf("Expecting only internal RAM to be filled")
+    t.Fail()
+  }
+
+  cpu.InitRAM(RAM_INIT_ALTERNATING, 0)
+  expected := []byte{0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00}
+  for i, value := range expected {
+    if cpu.memory[i] != value {
+      log.Printf("Expecting %X at %X, but got %X", value, i, cpu.memory[i])
+      t.Fail()
+    }
+  }
+
+  cpu.InitRAM(RAM_INIT_RANDOM, 1)
+  random := cpu.memory
+  cpu.InitRAM(RAM_INIT_RANDOM, 1)
+  if random != cpu.memory {
+    log.Printf("Expecting the same seed to give the same contents")
+    t.Fail()
+  }
+  cpu.InitRAM(RAM_INIT_RANDOM, 2)
+  if random == cpu.memory {
+    log.Printf("Expecting a different seed to give different contents")
+    t.Fail()
+  }
+
+  cpu.InitRAM(RAM_INIT_ZERO, 0)
+  if cpu.memory[0x0004] != 0 {
+    log.Printf("Expecting RAM to be cleared")
+    t.Fail()
+  }
+}
@@ -0,0 +1,42 @@
+package main
+
+// Represents the OAM DMA unit on the 2A03, which copies a page of CPU memory
+// into the PPU's OAM when $4014 is written to.
+type OAMDMA struct {
+  cpu *CPU
+  ppu *PPU
+}
+
+// Initializes a new OAM DMA unit.
+func OAMDMANew(cpu *CPU, ppu *PPU) *OAMDMA {
+  return &OAMDMA{cpu: cpu, ppu: ppu}
+}
+
+// Halts the CPU once the instruction that wrote the page is done, to copy the
+// page, from $XX00 to $XXFF, into OAM.
+func (d *OAMDMA) Write(address uint16, value byte) {
+  page := uint16(value) << 8
+  d.cpu.Halt(func() { d.transfer(page) })
+}
+
+// Copies a page into OAM through $2004, a byte at a time, over 513 cycles, or
+// 514 if it starts on an odd cycle.
+//
+// There's a cycle to halt the CPU, and another to line the reads up with even
+// cycles if need be, then a read and a write for every byte. A DMC fetch that
+// comes due along the way still reads its byte straight away, but the cycles
+// it takes get tacked on after the copy rather than pushing it back.
+func (d *OAMDMA) transfer(page uint16) {
+  c := d.cpu
+  odd := c.Cycles() % 2 == 1
+  c.clock()
+  if odd {
+    c.clock()
+  }
+  for i := uint16(0); i < 256; i++ {
+    value := c.read(page | i)
+    c.clock()
+    d.ppu.Write(0x2004, value)
+    c.clock()
+  }
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+import "log"
+
+func TestOAMDMA(t *testing.T) {
+  cpu := initCPUWithBasicInstructions([]byte{
+    0xEA, // NOP
+    0xEA, // NOP
+  })
+  ppu := initPPU()
+  cpu.MapWrite(0x4014, 0x4014, OAMDMANew(cpu, ppu))
+  for i := 0; i < 256; i++ {
+    cpu.memory.SetUint8At(0x0200 + uint16(i), byte(i))
+  }
+
+  // Starts off with OAMADDR not at 0, so the copy wraps around.
+  ppu.Write(0x2003, 0x10)
+  cpu.write(0x4014, 0x02)
+  if ppu.oam[0x11] != 0x00 {
+    log.Printf("Expecting OAM to be left alone until the instruction is done")
+    t.Fail()
+  }
+
+  // The NOP ends on cycle 2, which is even.
+  cpu.Step()
+  if ppu.oam[0x10] != 0x00 || ppu.oam[0x0F] != 0xFF {
+    log.Printf("Expecting OAM to hold page $02 starting at OAMADDR")
+    t.Fail()
+  }
+  if cpu.Cycles() != 2 + 513 {
+    log.Printf("Expecting the DMA to take 513 cycles on an even cycle, but got %d", cpu.Cycles() - 2)
+    t.Fail()
+  }
+  if cpu.lastRead != 0x02FF {
+    log.Printf("Expecting the last read to be from $02FF, but got %X", cpu.lastRead)
+    t.Fail()
+  }
+
+  // This NOP ends on an odd cycle.
+  cycles := cpu.Cycles() + 2
+  cpu.write(0x4014, 0x02)
+  cpu.Step()
+  if cpu.Cycles() - cycles != 514 {
+    log.Printf("Expecting the DMA to take 514 cycles on an odd cycle, but got %d", cpu.Cycles() - cycles)
+    t.Fail()
+  }
+}
@@ -0,0 +1,128 @@
+package main
+
+// The APU's delta modulation channel, which plays 1-bit delta-encoded samples
+// it fetches out of CPU memory by itself.
+type dmc struct {
+  // The DMC periods, in CPU cycles, for the console's region.
+  periods *[16]uint16
+  // What the samples get fetched through. Nothing gets fetched without one.
+  cpu *CPU
+
+  irqEnabled, loop, irq bool
+  period uint16
+  timer int
+
+  // The 7-bit level the channel puts out.
+  level byte
+
+  // Where the sample starts and how long it is, as written, and where the
+  // memory reader has got to playing it.
+  sampleAddress, sampleLength uint16
+  address, remaining uint16
+
+  // The sample buffer the memory reader fills, and the output unit's shift
+  // register that it gets emptied into.
+  buffer byte
+  bufferFull bool
+  shift byte
+  bitsRemaining byte
+  silence bool
+}
+
+// Writes one of the channel's registers.
+func (d *dmc) write(register uint16, value byte) {
+  switch register {
+  case 0:
+    d.irqEnabled = value & 0x80 != 0
+    d.loop = value & 0x40 != 0
+    d.period = d.periods[value & 0x0F]
+    if !d.irqEnabled {
+      d.irq = false
+    }
+  case 1:
+    d.level = value & 0x7F
+  case 2:
+    d.sampleAddress = 0xC000 | uint16(value) << 6
+  case 3:
+    d.sampleLength = uint16(value) << 4 | 1
+  }
+}
+
+// Starts the sample over from the beginning.
+func (d *dmc) restart() {
+  d.address = d.sampleAddress
+  d.remaining = d.sampleLength
+}
+
+// Enables or disables the channel through $4015. Enabling it only restarts
+// the sample if the last one has finished.
+func (d *dmc) setEnabled(enabled bool) {
+  d.irq = false
+  if !enabled {
+    d.remaining = 0
+  } else if d.remaining == 0 {
+    d.restart()
+  }
+}
+
+// Fills the sample buffer if it's empty and there's any of the sample left,
+// which takes the bus away from the CPU for a few cycles.
+func (d *dmc) fetch() {
+  if d.bufferFull || d.remaining == 0 || d.cpu == nil {
+    return
+  }
+  d.buffer = d.cpu.DMCFetch(d.address)
+  d.bufferFull = true
+  // The address wraps around to $8000, not $0000.
+  d.address++
+  if d.address == 0 {
+    d.address = 0x8000
+  }
+  d.remaining--
+  if d.remaining == 0 {
+    if d.loop {
+      d.restart()
+    } else if d.irqEnabled {
+      d.irq = true
+    }
+  }
+}
+
+// Runs the channel's timer for a CPU cycle, moving the level up or down by 2
+// for each bit of the sample every time it runs out.
+func (d *dmc) clockTimer() {
+  if d.timer > 0 {
+    d.timer--
+  } else {
+    d.timer = int(d.period) - 1
+
+    if !d.silence {
+      if d.shift & 1 != 0 {
+        if d.level <= 125 {
+          d.level += 2
+        }
+      } else if d.level >= 2 {
+        d.level -= 2
+      }
+    }
+    d.shift >>= 1
+
+    if d.bitsRemaining > 0 {
+      d.bitsRemaining--
+    }
+    if d.bitsRemaining == 0 {
+      d.bitsRemaining = 8
+      d.silence = !d.bufferFull
+      if d.bufferFull {
+        d.shift = d.buffer
+        d.bufferFull = false
+      }
+    }
+  }
+  d.fetch()
+}
+
+// Gets the channel's current output, from 0 to 127.
+func (d *dmc) output() byte {
+  return d.level
+}
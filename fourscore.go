@@ -0,0 +1,126 @@
+package main
+
+// The signatures the Four Score shifts out on $4016 and $4017 after the two
+// controllers on each, read starting from bit 0.
+var fourScoreSignatures = [2]byte{0x08, 0x04}
+
+// Represents the NES Four Score, which plugs into both controller ports and
+// takes four controllers.
+//
+// Each port shifts out 24 bits: the controller for player 1 or 2, then the one
+// for player 3 or 4, then a signature that lets games tell it's there.
+type FourScore struct {
+  controllers [4]*StandardController
+  strobe bool
+  shifts [2]uint32
+  reads [2]int
+}
+
+// Initializes a new Four Score, with a controller plugged into each socket.
+func FourScoreNew() *FourScore {
+  f := &FourScore{}
+  for i := range f.controllers {
+    f.controllers[i] = StandardControllerNew()
+  }
+  return f
+}
+
+// Gets the controller for one of the players, from 0 to 3.
+func (f *FourScore) Controller(player int) *StandardController {
+  return f.controllers[player]
+}
+
+// Gets what goes into one of the controller ports, 0 or 1.
+func (f *FourScore) Port(port int) InputDevice {
+  return &fourScorePort{fourScore: f, port: port}
+}
+
+// Latches the buttons of all four controllers.
+func (f *FourScore) latch() {
+  for port := range f.shifts {
+    f.shifts[port] = uint32(f.controllers[port].buttons) |
+      uint32(f.controllers[port + 2].buttons) << 8 |
+      uint32(fourScoreSignatures[port]) << 16
+    f.reads[port] = 0
+  }
+}
+
+// Takes the strobe.
+func (f *FourScore) write(value byte) {
+  f.strobe = value & 1 != 0
+  if f.strobe {
+    f.latch()
+  }
+}
+
+// Shifts the next bit out of one of the ports.
+func (f *FourScore) read(port int) byte {
+  if f.strobe {
+    f.latch()
+  }
+  if f.reads[port] >= 24 {
+    return 1
+  }
+  bit := byte(f.shifts[port] & 1)
+  f.shifts[port] >>= 1
+  if !f.strobe {
+    f.reads[port]++
+  }
+  return bit
+}
+
+// One side of the Four Score, as seen from one of the controller ports.
+type fourScorePort struct {
+  fourScore *FourScore
+  port int
+}
+
+func (p *fourScorePort) Write(value byte) { p.fourScore.write(value) }
+
+func (p *fourScorePort) Read(address uint16) byte { return p.fourScore.read(p.port) }
+
+func (p *fourScorePort) controllers() []*StandardController {
+  return []*StandardController{p.fourScore.controllers[p.port], p.fourScore.controllers[p.port + 2]}
+}
+
+// Plugs a Four Score into both controller ports.
+func (p *Ports) PlugFourScore(fourScore *FourScore) {
+  p.Plug(0, fourScore.Port(0))
+  p.Plug(1, fourScore.Port(1))
+}
+
+// Represents a Famicom four player adapter in its simple mode, where players
+// 3 and 4 plug into the expansion port and get read on bit 1 of $4016 and
+// $4017, alongside the controllers for players 1 and 2.
+type FamicomFourPlayers struct {
+  players [2]*StandardController
+}
+
+// Initializes a new Famicom four player adapter, with controllers for players
+// 3 and 4 plugged into it.
+func FamicomFourPlayersNew() *FamicomFourPlayers {
+  return &FamicomFourPlayers{
+    players: [2]*StandardController{StandardControllerNew(), StandardControllerNew()},
+  }
+}
+
+// Gets the controller for player 3 or 4, as 0 or 1.
+func (f *FamicomFourPlayers) Controller(player int) *StandardController {
+  return f.players[player]
+}
+
+func (f *FamicomFourPlayers) Write(value byte) {
+  for _, player := range f.players {
+    player.Write(value)
+  }
+}
+
+// Shifts the next bit out of player 3's controller for $4016, or player 4's
+// for $4017, onto bit 1.
+func (f *FamicomFourPlayers) Read(address uint16) byte {
+  return f.players[address & 1].Read(address) << 1
+}
+
+func (f *FamicomFourPlayers) controllers() []*StandardController {
+  return f.players[:]
+}
@@ -0,0 +1,91 @@
+package main
+
+// Where each key on the Family BASIC keyboard sits in its matrix: its row,
+// its column, and its bit in $4017.
+type keyboardKey struct {
+  row, column int
+  bit uint
+}
+
+// The keys on the Family BASIC keyboard, by the names printed on them, with
+// the function keys as F1 to F8 and the rest of the keys named in capitals.
+var familyBasicKeys = func() map[string]keyboardKey {
+  matrix := [9][2][4]string{
+    {{"]", "[", "RETURN", "F8"}, {"STOP", "YEN", "RSHIFT", "KANA"}},
+    {{";", ":", "@", "F7"}, {"^", "-", "/", "_"}},
+    {{"K", "L", "O", "F6"}, {"0", "P", ",", "."}},
+    {{"J", "U", "I", "F5"}, {"8", "9", "N", "M"}},
+    {{"H", "G", "Y", "F4"}, {"6", "7", "V", "B"}},
+    {{"D", "R", "T", "F3"}, {"4", "5", "C", "F"}},
+    {{"A", "S", "W", "F2"}, {"3", "E", "Z", "X"}},
+    {{"CTR", "Q", "ESC", "F1"}, {"2", "1", "GRPH", "LSHIFT"}},
+    {{"LEFT", "RIGHT", "UP", "CLR"}, {"INS", "DEL", "SPACE", "DOWN"}},
+  }
+  keys := map[string]keyboardKey{}
+  for row := range matrix {
+    for column := range matrix[row] {
+      for i, name := range matrix[row][column] {
+        keys[name] = keyboardKey{row: row, column: column, bit: uint(i) + 1}
+      }
+    }
+  }
+  return keys
+}()
+
+// Represents the Family BASIC keyboard, which goes in the Famicom's expansion
+// port.
+//
+// Its keys are a matrix of 9 rows of 2 columns of 4 keys. Writes to $4016 pick
+// the row and column, and reads of $4017 give the keys in them on bits 1 to
+// 4, as 0s for the keys being pressed.
+type FamilyBasicKeyboard struct {
+  keys [9][2]byte
+  row, column int
+  enabled bool
+}
+
+// Initializes a new Family BASIC keyboard, with nothing pressed.
+func FamilyBasicKeyboardNew() *FamilyBasicKeyboard {
+  return &FamilyBasicKeyboard{}
+}
+
+// Presses or releases one of the keys, by the name printed on it, or for the
+// keys with words on them, like RETURN, SPACE or F1, in capitals. It gives
+// back false if there's no such key.
+func (k *FamilyBasicKeyboard) SetKey(name string, pressed bool) bool {
+  key, ok := familyBasicKeys[name]
+  if !ok {
+    return false
+  }
+  if pressed {
+    k.keys[key.row][key.column] |= 1 << key.bit
+  } else {
+    k.keys[key.row][key.column] &^= 1 << key.bit
+  }
+  return true
+}
+
+// Picks the row and column. Bit 2 enables the keyboard, bit 0 goes back to
+// the first row, and bit 1 picks the column, moving on to the next row when
+// it goes from 1 to 0.
+func (k *FamilyBasicKeyboard) Write(value byte) {
+  k.enabled = value & 0x04 != 0
+  column := int(value >> 1 & 1)
+  if value & 0x01 != 0 {
+    k.row = 0
+  } else if k.column == 1 && column == 0 {
+    k.row++
+  }
+  k.column = column
+}
+
+// Gets the keys in the current row and column on $4017.
+func (k *FamilyBasicKeyboard) Read(address uint16) byte {
+  if address != 0x4017 || !k.enabled {
+    return 0
+  }
+  if k.row >= len(k.keys) {
+    return 0x1E
+  }
+  return ^k.keys[k.row][k.column] & 0x1E
+}
@@ -0,0 +1,86 @@
+package main
+
+import "fmt"
+
+// Represents the hardware on a cartridge that sits between the console's buses
+// and the cartridge's memories, and does any bank switching.
+type Mapper interface {
+  // Handles the CPU bus from $4020 to $FFFF.
+  Device
+
+  // Reads from the PPU bus, from $0000 to $1FFF.
+  ReadCHR(address uint16) byte
+
+  // Writes to the PPU bus, from $0000 to $1FFF.
+  WriteCHR(address uint16, value byte)
+
+  // Gets the nametable mirroring currently in effect.
+  Mirroring() byte
+}
+
+// The mappers that are implemented, by iNES mapper number.
+var mappers = map[uint16]func(cart *Cartridge) Mapper{
+  0: func(cart *Cartridge) Mapper { return NROMNew(cart) },
+}
+
+// Gives back an error if a mapper isn't implemented.
+func checkMapper(mapper uint16) error {
+  if _, ok := mappers[mapper]; !ok {
+    return fmt.Errorf("Mapper %d not supported", mapper)
+  }
+  return nil
+}
+
+// Initializes the mapper that the cartridge was built with.
+func MapperNew(cart *Cartridge) (Mapper, error) {
+  if err := checkMapper(cart.mapper); err != nil {
+    return nil, err
+  }
+  return mappers[cart.mapper](cart), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Represents the NROM board (mapper 0): 16 or 32 KiB of PRG ROM, 8 KiB of CHR,
+// and no bank switching.
+type NROM struct {
+  cart *Cartridge
+  prgRAM [0x2000]byte
+}
+
+// Initializes a new NROM board.
+func NROMNew(cart *Cartridge) *NROM {
+  return &NROM{cart: cart}
+}
+
+func (m *NROM) Read(address uint16) byte {
+  switch {
+  case address >= 0x8000:
+    // 16 KiB boards mirror their PRG ROM at $C000.
+    return m.cart.prg[int(address - 0x8000) % len(m.cart.prg)]
+  case address >= 0x6000:
+    return m.prgRAM[address - 0x6000]
+  }
+  return 0
+}
+
+func (m *NROM) Write(address uint16, value byte) {
+  if address >= 0x6000 && address < 0x8000 {
+    m.prgRAM[address - 0x6000] = value
+  }
+}
+
+func (m *NROM) ReadCHR(address uint16) byte {
+  if len(m.cart.chr) == 0 {
+    return 0
+  }
+  return m.cart.chr[int(address) % len(m.cart.chr)]
+}
+
+func (m *NROM) WriteCHR(address uint16, value byte) {
+  if m.cart.chrRAM && len(m.cart.chr) > 0 {
+    m.cart.chr[int(address) % len(m.cart.chr)] = value
+  }
+}
+
+func (m *NROM) Mirroring() byte { return m.cart.mirroring }
@@ -1,10 +1,43 @@
 package main
 
-const MEMORY_SIZE uint16 = 1024*64 - 1
+import "math/rand"
+
+const MEMORY_SIZE = 1024*64
+
+// The size of the console's internal RAM, at $0000 to $07FF.
+const RAM_SIZE = 0x800
+
+// What internal RAM can be filled with at power on. Hardware leaves it in a
+// state that varies from console to console, so games that read it before
+// writing to it can behave differently depending on which is picked.
+const (
+  // Every byte is $00.
+  RAM_INIT_ZERO byte = iota
+  // Every byte is $FF.
+  RAM_INIT_FF
+  // Every byte is random, from a seed.
+  RAM_INIT_RANDOM
+  // Runs of 4 bytes of $00, then 4 of $FF, the way a lot of consoles come up.
+  RAM_INIT_ALTERNATING
+)
 
 // Represents the NES RAM.
 type Memory [MEMORY_SIZE]byte
 
+// Internal RAM as the CPU bus sees it. Only the low 11 bits of the address get
+// decoded, so the 2 KiB of it repeat all the way up to $1FFF.
+type ram struct {
+  memory *Memory
+}
+
+func (r ram) Read(address uint16) byte {
+  return r.memory[address & (RAM_SIZE - 1)]
+}
+
+func (r ram) Write(address uint16, value byte) {
+  r.memory[address & (RAM_SIZE - 1)] = value
+}
+
 // Gets two contiguous bytes at the specified memory location, interpreting them
 // as little endian 16-bit integers.
 func (m *Memory) GetUint16LEAt(location uint16) uint16 {
@@ -19,6 +52,14 @@ func GetUint16LEAt(buffer []byte, location uint16) uint16 {
   return value
 }
 
+// Utility function to grab a 32-bit value, interpreting it as little-endian.
+func GetUint32LEAt(buffer []byte, location int) uint32 {
+  return uint32(buffer[location]) |
+    uint32(buffer[location+1]) << 8 |
+    uint32(buffer[location+2]) << 16 |
+    uint32(buffer[location+3]) << 24
+}
+
 // Gets the 8-bit integer at the specified memory location.
 func (m *Memory) GetUint8At(location uint16) byte {
   return m[location]
@@ -29,6 +70,25 @@ func (m *Memory) SetUint8At(address uint16, value byte) {
   m[address] = value
 }
 
+// Fills part of memory with one of the RAM_INIT patterns.
+func (m *Memory) fill(start uint16, size int, pattern byte, seed int64) {
+  random := rand.New(rand.NewSource(seed))
+  for i := 0; i < size; i++ {
+    var value byte
+    switch pattern {
+    case RAM_INIT_FF:
+      value = 0xFF
+    case RAM_INIT_RANDOM:
+      value = byte(random.Intn(256))
+    case RAM_INIT_ALTERNATING:
+      if i & 4 != 0 {
+        value = 0xFF
+      }
+    }
+    m[int(start) + i] = value
+  }
+}
+
 // Sets the memory with the instructions.
 func (m *Memory) SetInstructions(instructions []byte) {
   copy(m[0x8000:], instructions)
@@ -0,0 +1,305 @@
+package main
+
+import "encoding/binary"
+import "io"
+import "math"
+import "sync"
+
+// The formats the mixer can put samples out in, as interleaved stereo frames.
+const (
+  // Signed 16-bit little endian.
+  SAMPLE_INT16 = iota
+  // 32-bit little endian floats, from -1 to 1.
+  SAMPLE_FLOAT32
+)
+
+const (
+  // The number of taps in the band-limited step, and the number of positions
+  // between two samples it has been worked out for.
+  BLIP_TAPS = 16
+  BLIP_PHASES = 64
+  // The cutoff of the band-limited step, relative to the Nyquist frequency.
+  BLIP_CUTOFF = 0.9
+
+  // The cutoffs, in Hz, of the filters the console's output stage amounts to:
+  // two high-pass filters, then a low-pass one.
+  MIXER_HIGH_PASS_1 = 90
+  MIXER_HIGH_PASS_2 = 440
+  MIXER_LOW_PASS = 14000
+)
+
+// The nonlinear output levels of the pulse channels, indexed by the sum of
+// their outputs.
+var pulseTable = func() (table [31]float64) {
+  for i := 1; i < len(table); i++ {
+    table[i] = 95.52 / (8128 / float64(i) + 100)
+  }
+  return
+}()
+
+// The nonlinear output levels of the triangle, noise and DMC channels, indexed
+// by 3 times the triangle, plus 2 times the noise, plus the DMC.
+var tndTable = func() (table [203]float64) {
+  for i := 1; i < len(table); i++ {
+    table[i] = 163.67 / (24329 / float64(i) + 100)
+  }
+  return
+}()
+
+// Gets the APU's output, mixed the way the console does it, from 0 to about 1.
+func (a *APU) Output() float64 {
+  pulse := pulseTable[a.pulse1.output() + a.pulse2.output()]
+  tnd := tndTable[3 * int(a.triangle.output()) + 2 * int(a.noise.output()) + int(a.dmc.output())]
+  if a.expansion != nil {
+    return pulse + tnd + a.expansion.Output()
+  }
+  return pulse + tnd
+}
+
+// Impulse responses that, added up, make a step band-limited to below the
+// output's Nyquist frequency, for each of BLIP_PHASES positions between two
+// samples. Each is a windowed sinc that sums to 1.
+var blipKernel = func() (kernel [BLIP_PHASES][BLIP_TAPS]float64) {
+  for phase := range kernel {
+    sum := 0.0
+    for tap := range kernel[phase] {
+      x := float64(tap) - BLIP_TAPS / 2 - float64(phase) / BLIP_PHASES
+      sinc := 1.0
+      if x != 0 {
+        sinc = math.Sin(math.Pi * BLIP_CUTOFF * x) / (math.Pi * BLIP_CUTOFF * x)
+      }
+      // A Blackman window.
+      window := 0.42 + 0.5 * math.Cos(2 * math.Pi * x / BLIP_TAPS) + 0.08 * math.Cos(4 * math.Pi * x / BLIP_TAPS)
+      kernel[phase][tap] = sinc * window
+      sum += kernel[phase][tap]
+    }
+    for tap := range kernel[phase] {
+      kernel[phase][tap] /= sum
+    }
+  }
+  return
+}()
+
+// A first-order filter, either high-pass or low-pass.
+type filter struct {
+  highPass bool
+  alpha float64
+  lastInput, lastOutput float64
+}
+
+// Initializes a first-order filter with the given cutoff, in Hz.
+func filterNew(highPass bool, cutoff float64, sampleRate float64) filter {
+  rc := 1 / (2 * math.Pi * cutoff)
+  dt := 1 / sampleRate
+  if highPass {
+    return filter{highPass: true, alpha: rc / (rc + dt)}
+  }
+  return filter{alpha: dt / (rc + dt)}
+}
+
+// Runs a sample through the filter.
+func (f *filter) apply(input float64) float64 {
+  if f.highPass {
+    f.lastOutput = f.alpha * (f.lastOutput + input - f.lastInput)
+  } else {
+    f.lastOutput += f.alpha * (input - f.lastOutput)
+  }
+  f.lastInput = input
+  return f.lastOutput
+}
+
+// Turns a signal sampled at the CPU's clock rate into one at the output's
+// sample rate, without aliasing.
+//
+// Rather than sampling the signal, it adds a band-limited step to the output
+// every time the signal changes level, then filters the result like the
+// console's output stage does.
+type resampler struct {
+  // How far along the output each input sample goes, in output samples, and
+  // how far along the current input sample is, relative to deltas[0].
+  step float64
+  time float64
+
+  // The signal's last level, the band-limited steps waiting to be added up
+  // into samples, and the running total they get added up into.
+  level float64
+  deltas []float64
+  sum float64
+
+  filters []filter
+  // Samples ready to be taken out.
+  samples []float32
+}
+
+// Initializes a resampler from the given clock rate to the given sample rate.
+func resamplerNew(clockRate float64, sampleRate int) *resampler {
+  rate := float64(sampleRate)
+  return &resampler{
+    step: rate / clockRate,
+    deltas: make([]float64, BLIP_TAPS + 2),
+    filters: []filter{
+      filterNew(true, MIXER_HIGH_PASS_1, rate),
+      filterNew(true, MIXER_HIGH_PASS_2, rate),
+      filterNew(false, MIXER_LOW_PASS, rate),
+    },
+  }
+}
+
+// Takes in the signal's level for one input sample.
+func (r *resampler) add(level float64) {
+  if delta := level - r.level; delta != 0 {
+    r.level = level
+    whole := int(r.time)
+    phase := int((r.time - float64(whole)) * BLIP_PHASES)
+    for tap, weight := range blipKernel[phase] {
+      r.deltas[whole + tap] += delta * weight
+    }
+  }
+  r.time += r.step
+
+  // Samples before the current one can't have any more steps added to them,
+  // so they're done.
+  done := int(r.time)
+  if done == 0 {
+    return
+  }
+  for i := 0; i < done; i++ {
+    r.sum += r.deltas[i]
+    sample := r.sum
+    for f := range r.filters {
+      sample = r.filters[f].apply(sample)
+    }
+    r.samples = append(r.samples, float32(sample))
+  }
+  copy(r.deltas, r.deltas[done:])
+  for i := len(r.deltas) - done; i < len(r.deltas); i++ {
+    r.deltas[i] = 0
+  }
+  r.time -= float64(done)
+}
+
+// Takes out the samples that are ready.
+func (r *resampler) take() []float32 {
+  samples := r.samples
+  r.samples = nil
+  return samples
+}
+
+// Mixes the APU's channels together and resamples them to a host sample rate,
+// as stereo PCM.
+//
+// It's clocked alongside the CPU, and its output can be read through Read, or
+// handed off to a callback as it's made.
+type Mixer struct {
+  apu *APU
+  sampleRate int
+  format int
+  resampler *resampler
+
+  // Guards the callback and the buffer, since Read and SetCallback are
+  // usually called from a different thread than the one clocking the mixer.
+  mutex sync.Mutex
+  // Gets handed the samples as they're made, instead of them being buffered
+  // up for Read.
+  callback func(samples []float32)
+  // Samples waiting to be read.
+  buffer []float32
+}
+
+// Initializes a new mixer for the APU, at the given sample rate, putting out
+// samples in one of the SAMPLE formats.
+func MixerNew(apu *APU, sampleRate int, format int) *Mixer {
+  return &Mixer{
+    apu: apu,
+    sampleRate: sampleRate,
+    format: format,
+    resampler: resamplerNew(apu.region.CPUClockRate(), sampleRate),
+  }
+}
+
+// Gets the mixer's sample rate.
+func (m *Mixer) SampleRate() int { return m.sampleRate }
+
+// Has the mono samples handed off to the given function as they're made, in
+// which case they don't get buffered up for Read.
+func (m *Mixer) SetCallback(callback func(samples []float32)) {
+  m.mutex.Lock()
+  m.callback = callback
+  m.mutex.Unlock()
+}
+
+// Takes in the APU's output for a CPU cycle.
+func (m *Mixer) Clock() {
+  m.resampler.add(m.apu.Output())
+  if len(m.resampler.samples) == 0 {
+    return
+  }
+  samples := m.resampler.take()
+  m.mutex.Lock()
+  callback := m.callback
+  if callback == nil {
+    m.buffer = append(m.buffer, samples...)
+  }
+  m.mutex.Unlock()
+  // The callback gets called without the lock held, so that it can call back
+  // into the mixer.
+  if callback != nil {
+    callback(samples)
+  }
+}
+
+// Gets the number of bytes a stereo frame takes up.
+func (m *Mixer) frameSize() int {
+  if m.format == SAMPLE_FLOAT32 {
+    return 8
+  }
+  return 4
+}
+
+// Reads as many whole stereo frames as fit out of the samples mixed so far.
+// When it runs out of them, it pads the rest out with silence rather than
+// leaving the audio device waiting.
+//
+// It gives back io.ErrShortBuffer if there isn't room for a single frame.
+func (m *Mixer) Read(p []byte) (int, error) {
+  m.mutex.Lock()
+  defer m.mutex.Unlock()
+
+  size := m.frameSize()
+  if len(p) > 0 && len(p) < size {
+    return 0, io.ErrShortBuffer
+  }
+  frames := len(p) / size
+  for i := 0; i < frames; i++ {
+    var sample float32
+    if i < len(m.buffer) {
+      sample = m.buffer[i]
+    }
+    if sample > 1 { sample = 1 }
+    if sample < -1 { sample = -1 }
+
+    frame := p[i * size:]
+    if m.format == SAMPLE_FLOAT32 {
+      bits := math.Float32bits(sample)
+      binary.LittleEndian.PutUint32(frame, bits)
+      binary.LittleEndian.PutUint32(frame[4:], bits)
+    } else {
+      value := uint16(int16(sample * 32767))
+      binary.LittleEndian.PutUint16(frame, value)
+      binary.LittleEndian.PutUint16(frame[2:], value)
+    }
+  }
+
+  if frames > len(m.buffer) {
+    frames = len(m.buffer)
+  }
+  m.buffer = m.buffer[frames:]
+  return len(p) / size * size, nil
+}
+
+// Gets the number of frames mixed and waiting to be read.
+func (m *Mixer) Buffered() int {
+  m.mutex.Lock()
+  defer m.mutex.Unlock()
+  return len(m.buffer)
+}
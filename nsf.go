@@ -0,0 +1,413 @@
+package main
+
+import "bytes"
+import "errors"
+import "fmt"
+
+// The expansion sound chips an NSF can use, as flagged in its header.
+const (
+  NSF_CHIP_VRC6 byte = 1 << iota
+  NSF_CHIP_VRC7
+  NSF_CHIP_FDS
+  NSF_CHIP_MMC5
+  NSF_CHIP_N163
+  NSF_CHIP_S5B
+)
+
+// The names of the expansion sound chips, in the order of their bits.
+var nsfChipNames = []string{"VRC6", "VRC7", "FDS", "MMC5", "Namco 163", "Sunsoft 5B"}
+
+const (
+  // The size of an NSF header.
+  NSF_HEADER_SIZE = 0x80
+  // The size of the banks NSFs are switched in.
+  NSF_BANK_SIZE = 0x1000
+  // Where routines return to once they're done. INIT and PLAY get called with
+  // this as their return address, which is on the bank registers, so it's
+  // never code they'd run otherwise.
+  NSF_RETURN_ADDRESS uint16 = 0x5FF6
+  // The most CPU cycles INIT or PLAY get to return in, a bit over a second.
+  NSF_ROUTINE_TIMEOUT = 2000000
+)
+
+// Represents an NSF or NSFe music file.
+type NSF struct {
+  songs, startingSong int
+  loadAddress, initAddress, playAddress uint16
+  title, artist, copyright string
+  // How often PLAY gets called on NTSC and PAL, in microseconds.
+  ntscSpeed, palSpeed uint16
+  banks [8]byte
+  bankswitched bool
+  // Bit 0 is set for PAL, and bit 1 when it works on either.
+  timing byte
+  chips byte
+  data []byte
+}
+
+// Loads an NSF or NSFe file.
+func LoadNSF(data []byte) (*NSF, error) {
+  if bytes.HasPrefix(data, []byte("NESM\x1A")) {
+    return parseNSF(data)
+  }
+  if bytes.HasPrefix(data, []byte("NSFE")) {
+    return parseNSFe(data)
+  }
+  return nil, errors.New("Unknown NSF format")
+}
+
+// Gets a string out of a fixed-size, null-padded field.
+func nsfString(field []byte) string {
+  if i := bytes.IndexByte(field, 0); i >= 0 {
+    field = field[:i]
+  }
+  return string(field)
+}
+
+// Parses an NSF file.
+func parseNSF(data []byte) (*NSF, error) {
+  if len(data) < NSF_HEADER_SIZE {
+    return nil, errors.New("NSF header is truncated")
+  }
+  nsf := &NSF{
+    songs: int(data[0x06]),
+    startingSong: int(data[0x07]) - 1,
+    loadAddress: GetUint16LEAt(data, 0x08),
+    initAddress: GetUint16LEAt(data, 0x0A),
+    playAddress: GetUint16LEAt(data, 0x0C),
+    title: nsfString(data[0x0E:0x2E]),
+    artist: nsfString(data[0x2E:0x4E]),
+    copyright: nsfString(data[0x4E:0x6E]),
+    ntscSpeed: GetUint16LEAt(data, 0x6E),
+    palSpeed: GetUint16LEAt(data, 0x78),
+    timing: data[0x7A] & 0x03,
+    chips: data[0x7B],
+    data: data[NSF_HEADER_SIZE:],
+  }
+  copy(nsf.banks[:], data[0x70:0x78])
+  for _, bank := range nsf.banks {
+    if bank != 0 {
+      nsf.bankswitched = true
+    }
+  }
+  return nsf, nsf.validate()
+}
+
+// Parses an NSFe file, which is made up of chunks rather than a fixed header.
+func parseNSFe(data []byte) (*NSF, error) {
+  nsf := &NSF{}
+  info := false
+  offset := 4
+  for offset < len(data) {
+    if offset + 8 > len(data) {
+      return nil, errors.New("NSFe chunk header is truncated")
+    }
+    length := int(GetUint32LEAt(data, offset))
+    id := string(data[offset+4:offset+8])
+    offset += 8
+    if length < 0 || offset + length > len(data) {
+      return nil, fmt.Errorf("NSFe chunk %s is truncated", id)
+    }
+    chunk := data[offset:offset+length]
+    offset += length
+
+    switch id {
+    case "INFO":
+      if length < 9 {
+        return nil, errors.New("NSFe INFO chunk is truncated")
+      }
+      info = true
+      nsf.loadAddress = GetUint16LEAt(chunk, 0)
+      nsf.initAddress = GetUint16LEAt(chunk, 2)
+      nsf.playAddress = GetUint16LEAt(chunk, 4)
+      nsf.timing = chunk[6] & 0x03
+      nsf.chips = chunk[7]
+      nsf.songs = int(chunk[8])
+      if length > 9 {
+        nsf.startingSong = int(chunk[9])
+      }
+    case "DATA":
+      nsf.data = chunk
+    case "BANK":
+      copy(nsf.banks[:], chunk)
+      nsf.bankswitched = true
+    case "RATE":
+      if length >= 2 { nsf.ntscSpeed = GetUint16LEAt(chunk, 0) }
+      if length >= 4 { nsf.palSpeed = GetUint16LEAt(chunk, 2) }
+    case "auth":
+      fields := bytes.SplitN(chunk, []byte{0}, 4)
+      targets := []*string{&nsf.title, &nsf.artist, &nsf.copyright}
+      for i := 0; i < len(fields) && i < len(targets); i++ {
+        *targets[i] = string(fields[i])
+      }
+    case "NEND":
+      offset = len(data)
+    default:
+      // Chunks whose IDs start with a capital letter have to be understood
+      // to play the file properly.
+      if id[0] >= 'A' && id[0] <= 'Z' {
+        return nil, fmt.Errorf("NSFe chunk %s not supported", id)
+      }
+    }
+  }
+
+  if !info {
+    return nil, errors.New("NSFe file has no INFO chunk")
+  }
+  return nsf, nsf.validate()
+}
+
+// Checks that the file has everything it needs to be played.
+func (n *NSF) validate() error {
+  if len(n.data) == 0 {
+    return errors.New("NSF has no data")
+  }
+  if n.songs == 0 {
+    return errors.New("NSF has no songs")
+  }
+  if n.startingSong < 0 || n.startingSong >= n.songs {
+    n.startingSong = 0
+  }
+  if !n.bankswitched && n.loadAddress < 0x8000 {
+    return fmt.Errorf("NSF load address %04X is too low", n.loadAddress)
+  }
+  return nil
+}
+
+// Gets the number of songs.
+func (n *NSF) Songs() int { return n.songs }
+
+// Gets the song to start on, counting from 0.
+func (n *NSF) StartingSong() int { return n.startingSong }
+
+// Gets the title.
+func (n *NSF) Title() string { return n.title }
+
+// Gets the artist.
+func (n *NSF) Artist() string { return n.artist }
+
+// Gets the copyright holder.
+func (n *NSF) Copyright() string { return n.copyright }
+
+// Gets the expansion sound chips the music uses, as NSF_CHIP bits.
+func (n *NSF) Chips() byte { return n.chips }
+
+// Gets the names of the expansion sound chips the music uses.
+func (n *NSF) ChipNames() []string {
+  var names []string
+  for bit, name := range nsfChipNames {
+    if n.chips & (1 << uint(bit)) != 0 {
+      names = append(names, name)
+    }
+  }
+  return names
+}
+
+// Gets the region the music is meant to be played in. Files that work on
+// either play as NTSC.
+func (n *NSF) Region() *Region {
+  if n.timing & 0x03 == 0x01 {
+    return RegionPAL
+  }
+  return RegionNTSC
+}
+
+// The memory an NSF sees: the bank registers at $5FF8 to $5FFF, 8 KiB of RAM
+// at $6000, and the data, in 4 KiB banks, from $8000.
+type nsfMapper struct {
+  rom []byte
+  banks [8]byte
+  ram [0x2000]byte
+}
+
+// Lays the NSF's data out in banks. Files that aren't bankswitched are laid
+// out as one 32 KiB image starting at $8000.
+func nsfMapperNew(nsf *NSF) *nsfMapper {
+  offset := int(nsf.loadAddress & 0x0FFF)
+  if !nsf.bankswitched {
+    offset = int(nsf.loadAddress) - 0x8000
+  }
+  size := offset + len(nsf.data)
+  if size % NSF_BANK_SIZE != 0 {
+    size += NSF_BANK_SIZE - size % NSF_BANK_SIZE
+  }
+  if size < 8 * NSF_BANK_SIZE {
+    size = 8 * NSF_BANK_SIZE
+  }
+  m := &nsfMapper{rom: make([]byte, size)}
+  copy(m.rom[offset:], nsf.data)
+  m.reset(nsf)
+  return m
+}
+
+// Switches the initial banks in.
+func (m *nsfMapper) reset(nsf *NSF) {
+  for i := range m.banks {
+    if nsf.bankswitched {
+      m.banks[i] = nsf.banks[i]
+    } else {
+      m.banks[i] = byte(i)
+    }
+  }
+  m.ram = [0x2000]byte{}
+}
+
+func (m *nsfMapper) Read(address uint16) byte {
+  switch {
+  case address >= 0x8000:
+    bank := int(m.banks[(address - 0x8000) / NSF_BANK_SIZE]) % (len(m.rom) / NSF_BANK_SIZE)
+    return m.rom[bank * NSF_BANK_SIZE + int(address % NSF_BANK_SIZE)]
+  case address >= 0x6000:
+    return m.ram[address - 0x6000]
+  }
+  return 0
+}
+
+func (m *nsfMapper) Write(address uint16, value byte) {
+  switch {
+  case address >= 0x6000 && address < 0x8000:
+    m.ram[address - 0x6000] = value
+  case address >= 0x5FF8 && address <= 0x5FFF:
+    m.banks[address - 0x5FF8] = value
+  }
+}
+
+// Plays NSF music, on a CPU and APU of its own, without a PPU.
+type NSFPlayer struct {
+  nsf *NSF
+  cpu *CPU
+  apu *APU
+  mapper *nsfMapper
+  region *Region
+
+  // How many CPU cycles go by between calls to PLAY, and when it's next due.
+  playPeriod float64
+  nextPlay float64
+
+  // Takes in the APU's output while a song is being rendered.
+  mixer *Mixer
+}
+
+// Initializes a player for an NSF.
+func NSFPlayerNew(nsf *NSF) *NSFPlayer {
+  p := &NSFPlayer{
+    nsf: nsf,
+    cpu: CPUNew(),
+    apu: APUNew(),
+    mapper: nsfMapperNew(nsf),
+    region: nsf.Region(),
+  }
+  p.apu.SetRegion(p.region)
+  p.apu.Attach(p.cpu)
+  p.cpu.MapRAM()
+  p.cpu.Map(0x5FF8, 0xFFFF, p.mapper)
+  scheduler := SchedulerNew(p.region)
+  scheduler.Add(p.region.CPUDivider, p.apu.Clock)
+  scheduler.Add(p.region.CPUDivider, p.Clock)
+  p.cpu.Connect(scheduler)
+
+  speed := nsf.ntscSpeed
+  if p.region == RegionPAL {
+    speed = nsf.palSpeed
+  }
+  if speed == 0 {
+    speed = uint16(1000000 / p.region.FrameRate())
+  }
+  p.playPeriod = float64(speed) * p.region.CPUClockRate() / 1000000
+  return p
+}
+
+// Feeds the mixer, if a song is being rendered.
+func (p *NSFPlayer) Clock() {
+  if p.mixer != nil {
+    p.mixer.Clock()
+  }
+}
+
+// Runs a routine, with the given values in A and X, until it returns.
+func (p *NSFPlayer) call(address uint16, a byte, x byte) error {
+  c := p.cpu
+  c.push(byte((NSF_RETURN_ADDRESS - 1) >> 8))
+  c.push(byte((NSF_RETURN_ADDRESS - 1) & 0xFF))
+  c.pc = address
+  c.a = a
+  c.x = x
+
+  start := c.Cycles()
+  for c.pc != NSF_RETURN_ADDRESS {
+    if err := c.Step(); err != nil {
+      return fmt.Errorf("NSF routine at %04X: %s", address, err)
+    }
+    if c.Cycles() - start > NSF_ROUTINE_TIMEOUT {
+      return fmt.Errorf("NSF routine at %04X never returned", address)
+    }
+  }
+  return nil
+}
+
+// Starts playing one of the songs, counting from 0, by resetting the APU and
+// memory the way the NSF spec lays out and calling INIT.
+func (p *NSFPlayer) Init(song int) error {
+  if song < 0 || song >= p.nsf.songs {
+    return fmt.Errorf("NSF has no song %d", song)
+  }
+  c := p.cpu
+  for i := uint16(0); i < 0x0800; i++ {
+    c.memory.SetUint8At(i, 0)
+  }
+  p.mapper.reset(p.nsf)
+  for address := uint16(0x4000); address <= 0x4013; address++ {
+    c.write(address, 0)
+  }
+  c.write(0x4015, 0x00)
+  c.write(0x4015, 0x0F)
+  c.write(0x4017, 0x40)
+
+  c.sp = 0xFF
+  c.y = 0
+  c.setStatus(I, true)
+  var x byte
+  if p.region == RegionPAL {
+    x = 1
+  }
+  if err := p.call(p.nsf.initAddress, byte(song), x); err != nil {
+    return err
+  }
+  p.nextPlay = float64(c.Cycles())
+  return nil
+}
+
+// Runs the song for the given number of CPU cycles, calling PLAY at the rate
+// given in the header and letting the APU run in between.
+func (p *NSFPlayer) Run(cycles uint64) error {
+  c := p.cpu
+  end := c.Cycles() + cycles
+  for c.Cycles() < end {
+    if float64(c.Cycles()) >= p.nextPlay {
+      p.nextPlay += p.playPeriod
+      if err := p.call(p.nsf.playAddress, 0, 0); err != nil {
+        return err
+      }
+      continue
+    }
+    c.clock()
+  }
+  return nil
+}
+
+// Renders a number of seconds of one of the songs to mono samples at the
+// given sample rate, without needing an audio device.
+func (p *NSFPlayer) Render(song int, seconds float64, sampleRate int) ([]float32, error) {
+  var samples []float32
+  p.mixer = MixerNew(p.apu, sampleRate, SAMPLE_FLOAT32)
+  p.mixer.SetCallback(func(s []float32) {
+    samples = append(samples, s...)
+  })
+  defer func() { p.mixer = nil }()
+
+  if err := p.Init(song); err != nil {
+    return nil, err
+  }
+  err := p.Run(uint64(seconds * p.region.CPUClockRate()))
+  return samples, err
+}
@@ -0,0 +1,179 @@
+package main
+
+import "testing"
+import "log"
+
+// The code NSFs get built with in these tests. INIT stashes the song number at
+// $00, and PLAY counts how many times it's been called at $01.
+var testNSFCode = []byte{
+  // INIT, at $8000
+  0x85, 0x00, // STA $00
+  0x60,       // RTS
+  // PLAY, at $8003
+  0xA5, 0x01, // LDA $01
+  0x69, 0x01, // ADC #$01
+  0x85, 0x01, // STA $01
+  0x60,       // RTS
+}
+
+func buildNSF(banks []byte, data []byte) []byte {
+  nsf := make([]byte, NSF_HEADER_SIZE)
+  copy(nsf, "NESM\x1A\x01")
+  nsf[0x06] = 3
+  nsf[0x07] = 2
+  nsf[0x08], nsf[0x09] = 0x00, 0x80
+  nsf[0x0A], nsf[0x0B] = 0x00, 0x80
+  nsf[0x0C], nsf[0x0D] = 0x03, 0x80
+  copy(nsf[0x0E:], "Song")
+  copy(nsf[0x2E:], "Artist")
+  // 60 Hz.
+  nsf[0x6E], nsf[0x6F] = 0x1A, 0x41
+  copy(nsf[0x70:], banks)
+  nsf[0x7B] = NSF_CHIP_VRC6 | NSF_CHIP_N163
+  return append(nsf, data...)
+}
+
+func appendNSFeChunk(nsfe []byte, id string, data []byte) []byte {
+  length := len(data)
+  nsfe = append(nsfe, byte(length), byte(length >> 8), byte(length >> 16), byte(length >> 24))
+  nsfe = append(nsfe, []byte(id)...)
+  return append(nsfe, data...)
+}
+
+func TestLoadNSF(t *testing.T) {
+  nsf, err := LoadNSF(buildNSF(nil, testNSFCode))
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if nsf.Songs() != 3 || nsf.StartingSong() != 1 {
+    log.Printf("Expecting 3 songs starting on the second, but got %d and %d", nsf.Songs(), nsf.StartingSong())
+    t.Fail()
+  }
+  if nsf.Title() != "Song" || nsf.Artist() != "Artist" {
+    log.Printf("Expecting the title and artist, but got %q and %q", nsf.Title(), nsf.Artist())
+    t.Fail()
+  }
+  if names := nsf.ChipNames(); len(names) != 2 || names[0] != "VRC6" || names[1] != "Namco 163" {
+    log.Printf("Expecting the VRC6 and Namco 163, but got %v", names)
+    t.Fail()
+  }
+
+  nsfe := []byte("NSFE")
+  nsfe = appendNSFeChunk(nsfe, "INFO", []byte{0x00, 0x80, 0x00, 0x80, 0x03, 0x80, 0x01, 0x00, 4, 2})
+  nsfe = appendNSFeChunk(nsfe, "auth", []byte("Title\x00Artist\x00Copyright\x00Ripper\x00"))
+  nsfe = appendNSFeChunk(nsfe, "tlbl", []byte("Track\x00"))
+  nsfe = appendNSFeChunk(nsfe, "DATA", testNSFCode)
+  nsfe = appendNSFeChunk(nsfe, "NEND", nil)
+  nsf, err = LoadNSF(nsfe)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if nsf.Songs() != 4 || nsf.StartingSong() != 2 || nsf.Copyright() != "Copyright" || nsf.Region() != RegionPAL {
+    log.Printf("Expecting the NSFe's INFO and auth chunks to be read")
+    t.Fail()
+  }
+
+  // Chunks starting with a capital letter have to be understood.
+  nsfe = []byte("NSFE")
+  nsfe = appendNSFeChunk(nsfe, "INFO", []byte{0x00, 0x80, 0x00, 0x80, 0x03, 0x80, 0x00, 0x00, 1})
+  nsfe = appendNSFeChunk(nsfe, "WHAT", []byte{0})
+  if _, err := LoadNSF(nsfe); err == nil {
+    log.Printf("Expecting an unknown required chunk to be an error")
+    t.Fail()
+  }
+}
+
+func TestNSFBankswitching(t *testing.T) {
+  // Bank 1 has the code, and gets switched in at $8000.
+  data := make([]byte, NSF_BANK_SIZE * 2)
+  copy(data[NSF_BANK_SIZE:], testNSFCode)
+  data[0] = 0xAA
+  nsf, err := LoadNSF(buildNSF([]byte{1, 1, 2, 3, 4, 5, 6, 7}, data))
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  player := NSFPlayerNew(nsf)
+  if player.cpu.read(0x8000) != testNSFCode[0] {
+    log.Printf("Expecting bank 1 at $8000")
+    t.Fail()
+  }
+  player.cpu.write(0x5FF8, 0)
+  if player.cpu.read(0x8000) != 0xAA {
+    log.Printf("Expecting bank 0 at $8000 after writing $5FF8")
+    t.Fail()
+  }
+}
+
+func TestNSFPlayer(t *testing.T) {
+  nsf, _ := LoadNSF(buildNSF(nil, testNSFCode))
+  player := NSFPlayerNew(nsf)
+  if err := player.Init(2); err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if player.cpu.memory.GetUint8At(0x00) != 2 {
+    log.Printf("Expecting INIT to be called with the song in A")
+    t.Fail()
+  }
+  if player.apu.Read(0x4015) != 0 || !player.apu.irqInhibit {
+    log.Printf("Expecting the APU to be reset")
+    t.Fail()
+  }
+
+  // PLAY gets called at 60 Hz.
+  if err := player.Run(uint64(RegionNTSC.CPUClockRate())); err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if calls := player.cpu.memory.GetUint8At(0x01); calls < 59 || calls > 61 {
+    log.Printf("Expecting PLAY to be called 60 times, but got %d", calls)
+    t.Fail()
+  }
+
+  samples, err := player.Render(0, 0.5, 44100)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if len(samples) < 22040 || len(samples) > 22060 {
+    log.Printf("Expecting about 22050 samples, but got %d", len(samples))
+    t.Fail()
+  }
+  if player.cpu.memory.GetUint8At(0x00) != 0 {
+    log.Printf("Expecting INIT to be called for song 0")
+    t.Fail()
+  }
+}
+
+func TestNSFPlayerSubroutines(t *testing.T) {
+  code := []byte{
+    // INIT, at $8000
+    0x20, 0x08, 0x80, // JSR $8008
+    // PLAY, at $8003
+    0x60,             // RTS
+    0xEA, 0xEA, 0xEA, 0xEA,
+    // Counts up at $02 five times, at $8008
+    0xA2, 0x05,       // LDX #$05
+    0xE6, 0x02,       // INC $02
+    0xCA,             // DEX
+    0xD0, 0xFB,       // BNE $800A
+    0x60,             // RTS
+  }
+  nsf, _ := LoadNSF(buildNSF(nil, code))
+  player := NSFPlayerNew(nsf)
+  if err := player.Init(0); err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if player.cpu.memory.GetUint8At(0x02) != 5 {
+    log.Printf("Expecting the loop to run 5 times, but got %d", player.cpu.memory.GetUint8At(0x02))
+    t.Fail()
+  }
+  if player.cpu.sp != 0xFF {
+    log.Printf("Expecting INIT to leave the stack as it was, but got SP = %X", player.cpu.sp)
+    t.Fail()
+  }
+}
@@ -0,0 +1,135 @@
+package main
+
+import "image"
+import "image/color"
+import "math"
+
+const (
+  // The width of the images the NTSC filter puts out.
+  NTSC_WIDTH = 602
+  // Samples of the composite signal per pixel. The PPU puts out a pixel every
+  // 8 master clock half-cycles, and the colour subcarrier goes through a
+  // period every 12.
+  NTSC_SAMPLES_PER_PIXEL = 8
+  // How far the subcarrier's phase moves along from one scanline to the next:
+  // 341 dots of 8 samples each, modulo 12.
+  NTSC_SCANLINE_PHASE = DOTS_PER_SCANLINE * NTSC_SAMPLES_PER_PIXEL % 12
+)
+
+// A post-processing stage that turns frames into the composite signal the NES
+// puts out, then decodes that back to RGB the way a TV would, reproducing the
+// colour artifacts, dot crawl and blur that come with it.
+type NTSCFilter struct {
+  // How sharp the picture is, from -1 (blurry) to 1 (sharp). Sharper pictures
+  // let more of the colour subcarrier through as fringes.
+  Sharpness float64
+  // How much of the decoded signal makes it into the picture, from 0 (a clean
+  // picture, like an RGB monitor) to 1 (all of the artifacts).
+  Artifacts float64
+
+  // How saturated colours are, with 1 being normal, and how far hues are
+  // rotated, in degrees.
+  saturation, hue float64
+  // The palette the artifacts get mixed in with, which only depends on the
+  // saturation and hue, so it's kept around until they change.
+  palette *Palette
+}
+
+// Initializes an NTSC filter with settings that look like a regular TV.
+func NTSCFilterNew() *NTSCFilter {
+  f := &NTSCFilter{Sharpness: 0, Artifacts: 1, saturation: 1, hue: 0}
+  f.palette = GenerateCompositePalette(f.hue, f.saturation, 1)
+  return f
+}
+
+// Gets how saturated colours are, with 1 being normal.
+func (f *NTSCFilter) Saturation() float64 { return f.saturation }
+
+// Sets how saturated colours are, with 1 being normal.
+func (f *NTSCFilter) SetSaturation(saturation float64) {
+  if saturation != f.saturation {
+    f.saturation = saturation
+    f.palette = GenerateCompositePalette(f.hue, f.saturation, 1)
+  }
+}
+
+// Gets how far hues are rotated, in degrees.
+func (f *NTSCFilter) Hue() float64 { return f.hue }
+
+// Sets how far hues are rotated, in degrees.
+func (f *NTSCFilter) SetHue(hue float64) {
+  if hue != f.hue {
+    f.hue = hue
+    f.palette = GenerateCompositePalette(f.hue, f.saturation, 1)
+  }
+}
+
+// Runs a frame out of the PPU's framebuffer through the filter, giving back an
+// image NTSC_WIDTH pixels wide.
+//
+// The phase is where the colour subcarrier was, from 0 to 11, when the first
+// pixel of the frame was drawn; it's what makes the dot crawl move from frame
+// to frame.
+func (f *NTSCFilter) Apply(frame []uint16, phase int) *image.RGBA {
+  img := image.NewRGBA(image.Rect(0, 0, NTSC_WIDTH, FRAME_HEIGHT))
+
+  samples := FRAME_WIDTH * NTSC_SAMPLES_PER_PIXEL
+  lumaWidth := int(math.Max(4, math.Round(12 - 6 * f.Sharpness)))
+  chromaWidth := 24
+
+  // Running sums of the signal, and of it demodulated against the subcarrier,
+  // so that any window of it can be averaged in one go.
+  y := make([]float64, samples + 1)
+  i := make([]float64, samples + 1)
+  q := make([]float64, samples + 1)
+
+  // Averages one of the running sums over a window of samples centred on c.
+  average := func(sums []float64, c int, width int) float64 {
+    start := c - width / 2
+    end := start + width
+    if start < 0 { start = 0 }
+    if end > samples { end = samples }
+    if end <= start {
+      return 0
+    }
+    return (sums[end] - sums[start]) / float64(end - start)
+  }
+
+  for line := 0; line < FRAME_HEIGHT; line++ {
+    linePhase := phase + line * NTSC_SCANLINE_PHASE
+    row := frame[line * FRAME_WIDTH:(line + 1) * FRAME_WIDTH]
+
+    for s := 0; s < samples; s++ {
+      samplePhase := (linePhase + s) % 12
+      level := compositeLevel(row[s / NTSC_SAMPLES_PER_PIXEL], samplePhase)
+      angle := math.Pi / 6 * float64(samplePhase + COMPOSITE_BURST_PHASE) + f.hue * math.Pi / 180
+      y[s + 1] = y[s] + level
+      i[s + 1] = i[s] + level * math.Cos(angle)
+      q[s + 1] = q[s] + level * math.Sin(angle)
+    }
+
+    for x := 0; x < NTSC_WIDTH; x++ {
+      c := int((float64(x) + 0.5) * float64(samples) / NTSC_WIDTH)
+      decoded := yiqToRGB(
+        average(y, c, lumaWidth),
+        average(i, c, chromaWidth) * f.saturation,
+        average(q, c, chromaWidth) * f.saturation,
+      )
+      plain := f.palette.Color(row[c / NTSC_SAMPLES_PER_PIXEL])
+      img.SetRGBA(x, line, mixColors(plain, decoded, f.Artifacts))
+    }
+  }
+  return img
+}
+
+// Mixes two colours, going from all of a at 0 to all of b at 1.
+func mixColors(a, b color.RGBA, amount float64) color.RGBA {
+  mix := func(a, b byte) byte {
+    return byte(float64(a) + (float64(b) - float64(a)) * amount + 0.5)
+  }
+  return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 0xFF}
+}
+
+// Gets where the colour subcarrier was when the first pixel of the frame in the
+// framebuffer was drawn, for the NTSC filter.
+func (p *PPU) FramePhase() int { return p.framePhase }
@@ -0,0 +1,251 @@
+package main
+
+import "errors"
+import "image"
+import "image/color"
+import "io/ioutil"
+import "math"
+
+// How much the colour channels that aren't being emphasised get dimmed by each
+// of the emphasis bits that's set.
+const EMPHASIS_ATTENUATION = 0.816328
+
+// Maps the colours the PPU puts out to RGB.
+//
+// It's indexed the same way as the framebuffer: the palette index in the low 6
+// bits, and the red, green and blue emphasis bits in bits 6 to 8, so it holds
+// every colour under every combination of emphasis bits.
+type Palette [512]color.RGBA
+
+// Builds a palette out of the 64 colours the PPU can put out, working out what
+// they look like under the emphasis bits.
+func PaletteFromColors(colors [64]color.RGBA) *Palette {
+  palette := &Palette{}
+  for emphasis := 0; emphasis < 8; emphasis++ {
+    // Each emphasis bit dims the channels other than its own.
+    var attenuation [3]float64
+    for channel := 0; channel < 3; channel++ {
+      attenuation[channel] = 1
+      for bit := 0; bit < 3; bit++ {
+        if emphasis & (1 << uint(bit)) != 0 && bit != channel {
+          attenuation[channel] *= EMPHASIS_ATTENUATION
+        }
+      }
+    }
+
+    for i, c := range colors {
+      // Blacks aren't affected by emphasis.
+      if i & 0x0F >= 0x0E {
+        palette[emphasis << 6 | i] = c
+        continue
+      }
+      palette[emphasis << 6 | i] = color.RGBA{
+        R: byte(float64(c.R) * attenuation[0]),
+        G: byte(float64(c.G) * attenuation[1]),
+        B: byte(float64(c.B) * attenuation[2]),
+        A: 0xFF,
+      }
+    }
+  }
+  return palette
+}
+
+// Builds a palette out of 64 colours given as 0xRRGGBB.
+func paletteFromRGB(rgb [64]uint32) *Palette {
+  var colors [64]color.RGBA
+  for i, value := range rgb {
+    colors[i] = color.RGBA{R: byte(value >> 16), G: byte(value >> 8), B: byte(value), A: 0xFF}
+  }
+  return PaletteFromColors(colors)
+}
+
+// Approximates the colours of an NTSC 2C02.
+var Palette2C02 = paletteFromRGB([64]uint32{
+  0x666666, 0x002A88, 0x1412A7, 0x3B00A4, 0x5C007E, 0x6E0040, 0x6C0600, 0x561D00,
+  0x333500, 0x0B4800, 0x005200, 0x004F08, 0x00404D, 0x000000, 0x000000, 0x000000,
+  0xADADAD, 0x155FD9, 0x4240FF, 0x7527FE, 0xA01ACC, 0xB71E7B, 0xB53120, 0x994E00,
+  0x6B6D00, 0x388700, 0x0C9300, 0x008F32, 0x007C8D, 0x000000, 0x000000, 0x000000,
+  0xFFFEFF, 0x64B0FF, 0x9290FF, 0xC676FF, 0xF36AFF, 0xFE6ECC, 0xFE8170, 0xEA9E22,
+  0xBCBE00, 0x88D800, 0x5CE430, 0x45E082, 0x48CDDE, 0x4F4F4F, 0x000000, 0x000000,
+  0xFFFEFF, 0xC0DFFF, 0xD3D2FF, 0xE8C8FF, 0xFBC2FF, 0xFEC4EA, 0xFECCC5, 0xF7D8A5,
+  0xE4E594, 0xCFEF96, 0xBDF4AB, 0xB3F3CC, 0xB5EBF2, 0xB8B8B8, 0x000000, 0x000000,
+})
+
+// The colours of the 2C03 RGB PPU found in the Vs. System and PlayChoice-10,
+// given as 3-bit levels for red, green and blue.
+var Palette2C03 = paletteFromRGBLevels([64]uint16{
+  0333, 0014, 0006, 0326, 0403, 0503, 0510, 0420, 0320, 0120, 0031, 0040, 0022, 0000, 0000, 0000,
+  0555, 0036, 0027, 0407, 0507, 0704, 0700, 0630, 0430, 0140, 0040, 0053, 0044, 0000, 0000, 0000,
+  0777, 0357, 0447, 0637, 0707, 0737, 0740, 0750, 0660, 0360, 0070, 0276, 0077, 0000, 0000, 0000,
+  0777, 0567, 0657, 0757, 0747, 0755, 0764, 0772, 0773, 0572, 0473, 0276, 0467, 0000, 0000, 0000,
+})
+
+// Colours worked out by decoding the composite signal a 2C02 puts out, much
+// like a TV would.
+var PaletteComposite = GenerateCompositePalette(0, 1.0, 1.0)
+
+// The palette used when none is given.
+var DefaultPalette = Palette2C02
+
+// The palettes built in, by name.
+var BuiltinPalettes = map[string]*Palette{
+  "2c02": Palette2C02,
+  "2c03": Palette2C03,
+  "composite": PaletteComposite,
+}
+
+// Builds a palette out of 64 colours given as octal RGB triplets, 0 to 7 each.
+func paletteFromRGBLevels(levels [64]uint16) *Palette {
+  scale := func(level uint16) byte {
+    return byte(uint16(level & 7) * 255 / 7)
+  }
+  var colors [64]color.RGBA
+  for i, value := range levels {
+    colors[i] = color.RGBA{R: scale(value >> 6), G: scale(value >> 3), B: scale(value), A: 0xFF}
+  }
+  return PaletteFromColors(colors)
+}
+
+// Loads a palette out of a .pal file, which is either 64 RGB triplets (192
+// bytes), or 512 of them (1536 bytes) covering every combination of emphasis
+// bits as well.
+func LoadPalette(data []byte) (*Palette, error) {
+  switch len(data) {
+  case 64 * 3:
+    var colors [64]color.RGBA
+    for i := range colors {
+      colors[i] = color.RGBA{R: data[i * 3], G: data[i * 3 + 1], B: data[i * 3 + 2], A: 0xFF}
+    }
+    return PaletteFromColors(colors), nil
+  case 512 * 3:
+    palette := &Palette{}
+    for i := range palette {
+      palette[i] = color.RGBA{R: data[i * 3], G: data[i * 3 + 1], B: data[i * 3 + 2], A: 0xFF}
+    }
+    return palette, nil
+  }
+  return nil, errors.New("Palette files must be either 192 or 1536 bytes long")
+}
+
+// Loads a palette out of a .pal file on disk.
+func LoadPaletteFile(filename string) (*Palette, error) {
+  data, err := ioutil.ReadFile(filename)
+  if err != nil { return nil, err }
+  return LoadPalette(data)
+}
+
+// Levels of the composite signal the PPU puts out, relative to sync. The first
+// four are the low levels of each of the four luma levels, and the last four
+// the high ones.
+var compositeLevels = [8]float64{0.350, 0.518, 0.962, 1.550, 1.094, 1.506, 1.962, 1.962}
+
+const (
+  COMPOSITE_BLACK = 0.518
+  COMPOSITE_WHITE = 1.962
+  // How much the emphasis bits dim the signal while they're active.
+  COMPOSITE_EMPHASIS_ATTENUATION = 0.746
+  // Where the colour burst falls among the 12 phases of the subcarrier, which
+  // is the reference hues get decoded against.
+  COMPOSITE_BURST_PHASE = 4
+)
+
+// Works out the level of the composite signal the PPU puts out for a pixel at
+// one of the 12 phases of the colour subcarrier, scaled so that black is 0 and
+// white is 1.
+//
+// The PPU makes colours with a square wave between two levels, whose phase is
+// the hue; the emphasis bits dim the signal during a third of the wave each.
+func compositeLevel(pixel uint16, phase int) float64 {
+  hue := int(pixel & 0x0F)
+  luma := int(pixel >> 4) & 0x03
+  if hue >= 0x0E {
+    luma = 1
+  }
+  low := compositeLevels[luma]
+  high := compositeLevels[luma + 4]
+  switch {
+  case hue == 0x00: low = high
+  case hue >= 0x0D: high = low
+  }
+
+  inPhase := func(hue int) bool { return (hue + phase) % 12 < 6 }
+  level := low
+  if inPhase(hue) {
+    level = high
+  }
+  if (pixel & 0x040 != 0 && inPhase(0)) ||
+     (pixel & 0x080 != 0 && inPhase(4)) ||
+     (pixel & 0x100 != 0 && inPhase(8)) {
+    level *= COMPOSITE_EMPHASIS_ATTENUATION
+  }
+  return (level - COMPOSITE_BLACK) / (COMPOSITE_WHITE - COMPOSITE_BLACK)
+}
+
+// Converts a YIQ colour to RGB, applying gamma correction.
+func yiqToRGB(y, i, q float64) color.RGBA {
+  channel := func(value float64) byte {
+    if value <= 0 {
+      return 0
+    }
+    value = 255 * math.Pow(value, 2.2 / 1.8)
+    if value > 255 {
+      return 255
+    }
+    return byte(value)
+  }
+  return color.RGBA{
+    R: channel(y + 0.946882 * i + 0.623557 * q),
+    G: channel(y - 0.274788 * i - 0.635691 * q),
+    B: channel(y - 1.108545 * i + 1.709007 * q),
+    A: 0xFF,
+  }
+}
+
+// Generates a palette by decoding one period of the composite signal the PPU
+// puts out for each colour.
+//
+// The hue is rotated by the given number of degrees, and saturation and
+// contrast are scaled by the factors given.
+func GenerateCompositePalette(hue, saturation, contrast float64) *Palette {
+  palette := &Palette{}
+  for pixel := range palette {
+    var y, i, q float64
+    for phase := 0; phase < 12; phase++ {
+      level := compositeLevel(uint16(pixel), phase) / 12
+      angle := math.Pi / 6 * float64(phase + COMPOSITE_BURST_PHASE) + hue * math.Pi / 180
+      y += level
+      i += level * math.Cos(angle)
+      q += level * math.Sin(angle)
+    }
+    palette[pixel] = yiqToRGB(y * contrast, i * saturation * contrast, q * saturation * contrast)
+  }
+  return palette
+}
+
+// Gets the RGB colour of a pixel out of the framebuffer.
+func (p *Palette) Color(pixel uint16) color.RGBA {
+  return p[pixel & 0x1FF]
+}
+
+// Converts a frame out of the PPU's framebuffer to an image, using the given
+// palette, or the default one if it's nil.
+func FrameImage(frame []uint16, palette *Palette) *image.RGBA {
+  if palette == nil {
+    palette = DefaultPalette
+  }
+  img := image.NewRGBA(image.Rect(0, 0, FRAME_WIDTH, FRAME_HEIGHT))
+  for i, pixel := range frame {
+    c := palette.Color(pixel)
+    img.Pix[i * 4] = c.R
+    img.Pix[i * 4 + 1] = c.G
+    img.Pix[i * 4 + 2] = c.B
+    img.Pix[i * 4 + 3] = c.A
+  }
+  return img
+}
+
+// Gets the current frame as an image, using the given palette, or the default
+// one if it's nil.
+func (p *PPU) Image(palette *Palette) *image.RGBA {
+  return FrameImage(p.framebuffer[:], palette)
+}
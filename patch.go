@@ -0,0 +1,306 @@
+package main
+
+import "bytes"
+import "errors"
+import "hash/crc32"
+
+// The largest ROM image a UPS or BPS patch is allowed to make, which is well
+// over the size of anything that's been made for the console, and keeps a
+// corrupt or malicious patch from asking for an enormous allocation.
+const MAX_PATCHED_ROM_SIZE = 64 << 20
+
+// Applies an IPS, UPS or BPS patch to a ROM image, picking the format from the
+// magic bytes at the start of the patch.
+//
+// The ROM image passed in is never modified; the patched image is returned as a
+// new slice.
+func ApplyPatch(rom []byte, patch []byte) ([]byte, error) {
+  switch {
+  case bytes.HasPrefix(patch, []byte("PATCH")): return ApplyIPS(rom, patch)
+  case bytes.HasPrefix(patch, []byte("UPS1")): return ApplyUPS(rom, patch)
+  case bytes.HasPrefix(patch, []byte("BPS1")): return ApplyBPS(rom, patch)
+  }
+  return nil, errors.New("Unknown patch format")
+}
+
+// Applies an IPS patch to a ROM image.
+//
+// IPS has no checksums, so the only validation done is on the structure of the
+// patch itself.
+func ApplyIPS(rom []byte, patch []byte) ([]byte, error) {
+  if !bytes.HasPrefix(patch, []byte("PATCH")) {
+    return nil, errors.New("Not an IPS patch")
+  }
+
+  target := make([]byte, len(rom))
+  copy(target, rom)
+
+  // Grows the target so that it can hold end bytes.
+  grow := func(end int) {
+    if end > len(target) {
+      target = append(target, make([]byte, end - len(target))...)
+    }
+  }
+
+  i := 5
+  for {
+    if i + 3 > len(patch) {
+      return nil, errors.New("IPS patch is truncated")
+    }
+    if string(patch[i:i+3]) == "EOF" {
+      i += 3
+      break
+    }
+    offset := int(patch[i]) << 16 | int(patch[i+1]) << 8 | int(patch[i+2])
+    i += 3
+
+    if i + 2 > len(patch) {
+      return nil, errors.New("IPS patch is truncated")
+    }
+    size := int(patch[i]) << 8 | int(patch[i+1])
+    i += 2
+
+    if size == 0 {
+      // Run-length encoded record.
+      if i + 3 > len(patch) {
+        return nil, errors.New("IPS patch is truncated")
+      }
+      length := int(patch[i]) << 8 | int(patch[i+1])
+      value := patch[i+2]
+      i += 3
+      grow(offset + length)
+      for j := 0; j < length; j++ {
+        target[offset+j] = value
+      }
+    } else {
+      if i + size > len(patch) {
+        return nil, errors.New("IPS patch is truncated")
+      }
+      grow(offset + size)
+      copy(target[offset:], patch[i:i+size])
+      i += size
+    }
+  }
+
+  // Some IPS patches carry a 24-bit size the target gets truncated to.
+  if i + 3 == len(patch) {
+    size := int(patch[i]) << 16 | int(patch[i+1]) << 8 | int(patch[i+2])
+    if size < len(target) {
+      target = target[:size]
+    }
+  }
+
+  return target, nil
+}
+
+// Decodes one of the variable-length integers used by both UPS and BPS, and
+// advances the offset past it.
+func decodePatchNumber(patch []byte, offset *int) (uint64, error) {
+  var value uint64 = 0
+  var shift uint64 = 1
+  for {
+    if *offset >= len(patch) {
+      return 0, errors.New("Patch is truncated")
+    }
+    x := patch[*offset]
+    *offset++
+    value += uint64(x & 0x7F) * shift
+    if x & 0x80 != 0 {
+      break
+    }
+    shift <<= 7
+    value += shift
+  }
+  return value, nil
+}
+
+// Reads the three CRC32s at the end of a UPS or BPS patch, and validates the
+// one covering the patch itself.
+func patchChecksums(patch []byte) (source, target uint32, err error) {
+  if len(patch) < 12 {
+    return 0, 0, errors.New("Patch is truncated")
+  }
+  footer := patch[len(patch)-12:]
+  source = uint32(footer[0]) | uint32(footer[1]) << 8 | uint32(footer[2]) << 16 | uint32(footer[3]) << 24
+  target = uint32(footer[4]) | uint32(footer[5]) << 8 | uint32(footer[6]) << 16 | uint32(footer[7]) << 24
+  self := uint32(footer[8]) | uint32(footer[9]) << 8 | uint32(footer[10]) << 16 | uint32(footer[11]) << 24
+  if crc32.ChecksumIEEE(patch[:len(patch)-4]) != self {
+    return 0, 0, errors.New("Patch checksum mismatch")
+  }
+  return source, target, nil
+}
+
+// Applies a UPS patch to a ROM image, validating the checksums of the patch, the
+// ROM it applies to, and the result.
+func ApplyUPS(rom []byte, patch []byte) ([]byte, error) {
+  if !bytes.HasPrefix(patch, []byte("UPS1")) {
+    return nil, errors.New("Not a UPS patch")
+  }
+  sourceCRC, targetCRC, err := patchChecksums(patch)
+  if err != nil { return nil, err }
+  if crc32.ChecksumIEEE(rom) != sourceCRC {
+    return nil, errors.New("ROM does not match the one the UPS patch was made for")
+  }
+
+  i := 4
+  sourceSize, err := decodePatchNumber(patch, &i)
+  if err != nil { return nil, err }
+  targetSize, err := decodePatchNumber(patch, &i)
+  if err != nil { return nil, err }
+  if sourceSize != uint64(len(rom)) {
+    return nil, errors.New("ROM size does not match the UPS patch")
+  }
+  if targetSize > MAX_PATCHED_ROM_SIZE {
+    return nil, errors.New("Patched ROM is too large")
+  }
+
+  target := make([]byte, targetSize)
+  copy(target, rom)
+
+  // The patch covers whichever is the bigger of the ROM and the target.
+  limit := sourceSize
+  if targetSize > limit {
+    limit = targetSize
+  }
+
+  end := len(patch) - 12
+  position := uint64(0)
+  for i < end {
+    skip, err := decodePatchNumber(patch, &i)
+    if err != nil { return nil, err }
+    if position > limit || skip > limit - position {
+      return nil, errors.New("UPS patch writes past the end of the target")
+    }
+    position += skip
+    for {
+      if i >= end {
+        return nil, errors.New("UPS patch is truncated")
+      }
+      x := patch[i]
+      i++
+      if x == 0 {
+        position++
+        break
+      }
+      if position < targetSize {
+        target[position] ^= x
+      }
+      position++
+    }
+  }
+
+  if crc32.ChecksumIEEE(target) != targetCRC {
+    return nil, errors.New("Patched ROM checksum mismatch")
+  }
+  return target, nil
+}
+
+// Applies a BPS patch to a ROM image, validating the checksums of the patch, the
+// ROM it applies to, and the result.
+func ApplyBPS(rom []byte, patch []byte) ([]byte, error) {
+  if !bytes.HasPrefix(patch, []byte("BPS1")) {
+    return nil, errors.New("Not a BPS patch")
+  }
+  sourceCRC, targetCRC, err := patchChecksums(patch)
+  if err != nil { return nil, err }
+  if crc32.ChecksumIEEE(rom) != sourceCRC {
+    return nil, errors.New("ROM does not match the one the BPS patch was made for")
+  }
+
+  i := 4
+  sourceSize, err := decodePatchNumber(patch, &i)
+  if err != nil { return nil, err }
+  targetSize, err := decodePatchNumber(patch, &i)
+  if err != nil { return nil, err }
+  metadataSize, err := decodePatchNumber(patch, &i)
+  if err != nil { return nil, err }
+  if sourceSize != uint64(len(rom)) {
+    return nil, errors.New("ROM size does not match the BPS patch")
+  }
+  if targetSize > MAX_PATCHED_ROM_SIZE {
+    return nil, errors.New("Patched ROM is too large")
+  }
+  end := len(patch) - 12
+  if i > end || metadataSize > uint64(end - i) {
+    return nil, errors.New("BPS patch is truncated")
+  }
+  i += int(metadataSize)
+
+  target := make([]byte, targetSize)
+  var output, sourceOffset, targetOffset int
+
+  // Reads a signed relative offset for the copy commands. Neither the source
+  // nor the target is anywhere near big enough for an offset bigger than both
+  // of them put together to be any good.
+  relative := func() (int, error) {
+    data, err := decodePatchNumber(patch, &i)
+    if err != nil { return 0, err }
+    if data >> 1 > uint64(len(rom) + len(target)) {
+      return 0, errors.New("BPS patch copies from outside the ROM")
+    }
+    offset := int(data >> 1)
+    if data & 1 != 0 {
+      offset = -offset
+    }
+    return offset, nil
+  }
+
+  for i < end {
+    data, err := decodePatchNumber(patch, &i)
+    if err != nil { return nil, err }
+    command := data & 3
+    if data >> 2 >= uint64(len(target) - output) {
+      return nil, errors.New("BPS patch writes past the end of the target")
+    }
+    length := int(data >> 2) + 1
+
+    switch command {
+    // SourceRead
+    case 0:
+      if output + length > len(rom) {
+        return nil, errors.New("BPS patch reads past the end of the source")
+      }
+      copy(target[output:], rom[output:output+length])
+      output += length
+    // TargetRead
+    case 1:
+      if i + length > end {
+        return nil, errors.New("BPS patch is truncated")
+      }
+      copy(target[output:], patch[i:i+length])
+      i += length
+      output += length
+    // SourceCopy
+    case 2:
+      offset, err := relative()
+      if err != nil { return nil, err }
+      sourceOffset += offset
+      if sourceOffset < 0 || sourceOffset + length > len(rom) {
+        return nil, errors.New("BPS patch reads past the end of the source")
+      }
+      copy(target[output:], rom[sourceOffset:sourceOffset+length])
+      sourceOffset += length
+      output += length
+    // TargetCopy
+    case 3:
+      offset, err := relative()
+      if err != nil { return nil, err }
+      targetOffset += offset
+      if targetOffset < 0 || targetOffset >= output {
+        return nil, errors.New("BPS patch copies from outside the target")
+      }
+      // The regions may overlap, which is how BPS encodes runs, so this has to
+      // go byte by byte.
+      for j := 0; j < length; j++ {
+        target[output] = target[targetOffset]
+        output++
+        targetOffset++
+      }
+    }
+  }
+
+  if crc32.ChecksumIEEE(target) != targetCRC {
+    return nil, errors.New("Patched ROM checksum mismatch")
+  }
+  return target, nil
+}
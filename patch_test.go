@@ -0,0 +1,199 @@
+package main
+
+import "testing"
+import "log"
+import "bytes"
+import "hash/crc32"
+
+// Encodes a number the way UPS and BPS do.
+func encodePatchNumber(value uint64) []byte {
+  var out []byte
+  for {
+    x := byte(value & 0x7F)
+    value >>= 7
+    if value == 0 {
+      return append(out, 0x80 | x)
+    }
+    out = append(out, x)
+    value--
+  }
+}
+
+func appendCRC(buffer []byte, crc uint32) []byte {
+  return append(buffer, byte(crc), byte(crc >> 8), byte(crc >> 16), byte(crc >> 24))
+}
+
+// Finishes off a UPS or BPS patch with the source, target and patch checksums.
+func finishPatch(patch []byte, source []byte, target []byte) []byte {
+  patch = appendCRC(patch, crc32.ChecksumIEEE(source))
+  patch = appendCRC(patch, crc32.ChecksumIEEE(target))
+  return appendCRC(patch, crc32.ChecksumIEEE(patch))
+}
+
+func TestApplyIPS(t *testing.T) {
+  rom := []byte{0, 1, 2, 3, 4, 5}
+  patch := []byte("PATCH")
+  patch = append(patch, 0, 0, 1, 0, 2, 0xAA, 0xBB)   // Write two bytes at 1
+  patch = append(patch, 0, 0, 5, 0, 0, 0, 3, 0xCC)   // RLE three bytes at 5
+  patch = append(patch, []byte("EOF")...)
+
+  result, err := ApplyPatch(rom, patch)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  expected := []byte{0, 0xAA, 0xBB, 3, 4, 0xCC, 0xCC, 0xCC}
+  if !bytes.Equal(result, expected) {
+    log.Printf("Expecting %X, but got %X", expected, result)
+    t.Fail()
+  }
+  if rom[1] != 1 {
+    log.Printf("The original ROM was modified")
+    t.Fail()
+  }
+
+  if _, err := ApplyIPS(rom, []byte("PATCH\x00\x00")); err == nil {
+    log.Printf("Expecting a truncated IPS patch to fail")
+    t.Fail()
+  }
+}
+
+func TestApplyUPS(t *testing.T) {
+  rom := []byte{1, 2, 3, 4}
+  target := []byte{1, 7, 3, 4, 9}
+
+  patch := []byte("UPS1")
+  patch = append(patch, encodePatchNumber(uint64(len(rom)))...)
+  patch = append(patch, encodePatchNumber(uint64(len(target)))...)
+  patch = append(patch, encodePatchNumber(1)...)
+  patch = append(patch, 2 ^ 7, 0)
+  patch = append(patch, encodePatchNumber(1)...)
+  patch = append(patch, 9, 0)
+  patch = finishPatch(patch, rom, target)
+
+  result, err := ApplyPatch(rom, patch)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if !bytes.Equal(result, target) {
+    log.Printf("Expecting %X, but got %X", target, result)
+    t.Fail()
+  }
+
+  if _, err := ApplyUPS([]byte{1, 2, 3, 5}, patch); err == nil {
+    log.Printf("Expecting a source checksum mismatch")
+    t.Fail()
+  }
+
+  patch[6] ^= 0xFF
+  if _, err := ApplyUPS(rom, patch); err == nil {
+    log.Printf("Expecting a patch checksum mismatch")
+    t.Fail()
+  }
+}
+
+func TestApplyBPS(t *testing.T) {
+  rom := []byte{1, 2, 3, 4}
+  target := []byte{1, 2, 9, 9, 9, 9, 3, 4}
+
+  patch := []byte("BPS1")
+  patch = append(patch, encodePatchNumber(uint64(len(rom)))...)
+  patch = append(patch, encodePatchNumber(uint64(len(target)))...)
+  patch = append(patch, encodePatchNumber(0)...)
+  // SourceRead 2 bytes
+  patch = append(patch, encodePatchNumber((2 - 1) << 2 | 0)...)
+  // TargetRead 1 byte
+  patch = append(patch, encodePatchNumber((1 - 1) << 2 | 1)...)
+  patch = append(patch, 9)
+  // TargetCopy 3 bytes from offset 2, overlapping what it writes
+  patch = append(patch, encodePatchNumber((3 - 1) << 2 | 3)...)
+  patch = append(patch, encodePatchNumber(2 << 1)...)
+  // SourceCopy 2 bytes from offset 2
+  patch = append(patch, encodePatchNumber((2 - 1) << 2 | 2)...)
+  patch = append(patch, encodePatchNumber(2 << 1)...)
+  patch = finishPatch(patch, rom, target)
+
+  result, err := ApplyPatch(rom, patch)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if !bytes.Equal(result, target) {
+    log.Printf("Expecting %X, but got %X", target, result)
+    t.Fail()
+  }
+}
+
+func TestLoadCartridgeWithPatch(t *testing.T) {
+  rom := make([]byte, 16 + PRG_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x01\x00\x01\x00"))
+
+  // Changes the mapper number in the header.
+  patch := []byte("PATCH")
+  patch = append(patch, 0, 0, 6, 0, 1, 0x21)
+  patch = append(patch, []byte("EOF")...)
+
+  cart, err := LoadCartridge(rom, patch)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if cart.MapperNumber() != 2 {
+    log.Printf("Expecting mapper 2, but got %d", cart.MapperNumber())
+    t.Fail()
+  }
+  if cart.Mirroring() != MIRROR_VERTICAL {
+    log.Printf("Expecting vertical mirroring")
+    t.Fail()
+  }
+  if !cart.chrRAM {
+    log.Printf("Expecting CHR RAM when the ROM has no CHR banks")
+    t.Fail()
+  }
+  if rom[6] != 0x01 {
+    log.Printf("The original ROM was modified")
+    t.Fail()
+  }
+}
+
+func TestMalformedPatches(t *testing.T) {
+  rom := []byte{0, 1, 2, 3}
+  size := encodePatchNumber(uint64(len(rom)))
+  build := func(magic string, fields ...[]byte) []byte {
+    patch := []byte(magic)
+    for _, field := range fields {
+      patch = append(patch, field...)
+    }
+    return finishPatch(patch, rom, rom)
+  }
+
+  patches := map[string][]byte{
+    "BPS metadata past the end": build("BPS1", size, size, encodePatchNumber(1 << 63)),
+    "BPS target too large": build("BPS1", size, encodePatchNumber(1 << 40), encodePatchNumber(0)),
+    "BPS length past the end": build("BPS1", size, size, encodePatchNumber(0),
+      encodePatchNumber(1 << 62 | 1)),
+    "BPS offset out of range": build("BPS1", size, size, encodePatchNumber(0),
+      encodePatchNumber(2), encodePatchNumber(1 << 62)),
+    "UPS target too large": build("UPS1", size, encodePatchNumber(1 << 40)),
+    "UPS skip past the end": build("UPS1", size, size, encodePatchNumber(1 << 63), []byte{1, 0}),
+  }
+  for name, patch := range patches {
+    if _, err := ApplyPatch(rom, patch); err == nil {
+      log.Printf("Expecting an error for %s", name)
+      t.Fail()
+    }
+  }
+}
+
+func TestLoadCartridgeSizeOutOfRange(t *testing.T) {
+  rom := make([]byte, 16 + PRG_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x01\x00\x00\x08"))
+  // An exponent-multiplier PRG size of 2^63 * 1.
+  rom[4] = 0xFC
+  rom[9] = 0x0F
+  if _, err := LoadCartridge(rom); err == nil {
+    log.Printf("Expecting an out of range PRG size to fail")
+    t.Fail()
+  }
+}
@@ -0,0 +1,61 @@
+package main
+
+// The order the Power Pad's buttons, numbered from 1 to 12, get shifted out
+// on bits 4 and 3 of $4017.
+var powerPadOrder = [2][]int{
+  {2, 1, 5, 9, 6, 10, 11, 7},
+  {4, 3, 12, 8},
+}
+
+// Represents the Power Pad, a mat with 12 buttons on it that goes in port 2.
+//
+// It's two shift registers, one shifting out 8 of the buttons on bit 4 and the
+// other the remaining 4 on bit 3, both reading as 1s once they're done.
+type PowerPad struct {
+  buttons uint16
+  strobe bool
+  shifts [2]uint16
+}
+
+// Initializes a new Power Pad, with nothing pressed.
+func PowerPadNew() *PowerPad {
+  return &PowerPad{}
+}
+
+// Sets which buttons are pressed, with button n on bit n - 1.
+func (p *PowerPad) SetButtons(buttons uint16) {
+  p.buttons = buttons
+}
+
+// Latches the buttons into the shift registers, in the order they go out.
+func (p *PowerPad) latch() {
+  for i, order := range powerPadOrder {
+    p.shifts[i] = 0xFFFF
+    for bit, button := range order {
+      if p.buttons & (1 << uint(button - 1)) == 0 {
+        p.shifts[i] &^= 1 << uint(bit)
+      }
+    }
+  }
+}
+
+// Takes the strobe.
+func (p *PowerPad) Write(value byte) {
+  p.strobe = value & 1 != 0
+  if p.strobe {
+    p.latch()
+  }
+}
+
+// Shifts the next bits out, onto bits 4 and 3.
+func (p *PowerPad) Read(address uint16) byte {
+  if p.strobe {
+    p.latch()
+  }
+  value := byte(p.shifts[0] & 1) << 4 | byte(p.shifts[1] & 1) << 3
+  if !p.strobe {
+    p.shifts[0] = p.shifts[0] >> 1 | 0x8000
+    p.shifts[1] = p.shifts[1] >> 1 | 0x8000
+  }
+  return value
+}
@@ -0,0 +1,764 @@
+package main
+
+// PPUCTRL ($2000) bits
+const (
+  CTRL_NAMETABLE byte = 0x03
+  CTRL_INCREMENT byte = 0x04
+  CTRL_SPRITE_TABLE byte = 0x08
+  CTRL_BACKGROUND_TABLE byte = 0x10
+  CTRL_SPRITE_SIZE byte = 0x20
+  CTRL_NMI byte = 0x80
+)
+
+// PPUMASK ($2001) bits
+const (
+  MASK_GRAYSCALE byte = 0x01
+  MASK_BACKGROUND_LEFT byte = 0x02
+  MASK_SPRITES_LEFT byte = 0x04
+  MASK_BACKGROUND byte = 0x08
+  MASK_SPRITES byte = 0x10
+  MASK_EMPHASIS byte = 0xE0
+)
+
+// PPUSTATUS ($2002) bits
+const (
+  STATUS_OVERFLOW byte = 0x20
+  STATUS_SPRITE_ZERO byte = 0x40
+  STATUS_VBLANK byte = 0x80
+)
+
+// Frame geometry. The scanline counts are NTSC's; other regions have their own.
+const (
+  FRAME_WIDTH = 256
+  FRAME_HEIGHT = 240
+  DOTS_PER_SCANLINE = 341
+  SCANLINES_PER_FRAME = 262
+  VBLANK_SCANLINE = 241
+)
+
+// How many dots it takes for a bit on the PPU's I/O latch to decay to 0 after
+// it was last refreshed. On hardware it's somewhere around 600ms.
+const PPU_OPEN_BUS_DECAY uint64 = 5369318 * 6 / 10
+
+// Represents the 2C02 PPU.
+type PPU struct {
+  // Registers
+  ctrl, mask, status, oamAddr byte
+
+  // The internal scroll registers: the current and temporary VRAM addresses,
+  // the fine X scroll, and the write toggle shared between $2005 and $2006.
+  v, t uint16
+  x byte
+  w bool
+
+  // The buffer that $2007 reads go through.
+  buffer byte
+
+  // The I/O latch, which is what reads of write-only registers return, along
+  // with the dot at which each of its bits was last refreshed.
+  latch byte
+  latchRefreshed [8]uint64
+
+  // The number of dots elapsed since power on, where the beam is within the
+  // current frame, and the number of frames rendered so far.
+  clock uint64
+  scanline, dot int
+  frame uint64
+
+  // Where the colour subcarrier was, out of its 12 phases, when the first
+  // pixel of the frame in the framebuffer was drawn.
+  framePhase int
+
+  // Whether rendering is on as far as the pipeline is concerned, which lags a
+  // dot behind PPUMASK.
+  rendering bool
+  // Whether PPUSTATUS was read on the dot just before vblank starts, which
+  // stops the flag from being set for this frame.
+  suppressVblank bool
+
+  // The background tile being fetched, and the shift registers that the tiles
+  // already fetched get drawn out of.
+  nametableByte, attributeByte, patternLow, patternHigh byte
+  patternShiftLow, patternShiftHigh uint16
+  attributeShiftLow, attributeShiftHigh uint16
+
+  // Secondary OAM, and where sprite evaluation has got to filling it in: the
+  // sprite (n) and byte (m) in OAM being looked at, the next free byte in
+  // secondary OAM, and the byte read off OAM on the last odd dot.
+  secondaryOAM [32]byte
+  evaluationN, evaluationM, evaluationIndex int
+  evaluationDone bool
+  evaluationBuffer byte
+  spriteZeroNext bool
+
+  // The sprites fetched for the scanline being drawn.
+  spriteCount int
+  spritePatternLow, spritePatternHigh [8]byte
+  spriteAttributes, spriteX [8]byte
+  spriteZeroOnLine bool
+
+  // Every pixel drawn so far, as a palette index in the low 6 bits with the
+  // red, green and blue emphasis bits in bits 6 to 8.
+  framebuffer [FRAME_WIDTH * FRAME_HEIGHT]uint16
+
+  oam [256]byte
+  nametables [0x1000]byte
+  palette [32]byte
+  mapper Mapper
+
+  // The console's timing.
+  region *Region
+}
+
+// Initializes a new PPU, reading pattern tables off the given cartridge.
+func PPUNew(mapper Mapper) *PPU {
+  return &PPU{mapper: mapper, region: RegionNTSC}
+}
+
+// Sets the console's timing, which decides how many scanlines there are.
+//
+// How many dots go by for every CPU cycle is down to the Scheduler, which
+// should step the PPU every region.PPUDivider master clock cycles.
+func (p *PPU) SetRegion(region *Region) {
+  p.region = region
+}
+
+// Resets the PPU, the way the reset button does. PPUCTRL, PPUMASK, the scroll
+// and the read buffer get cleared, while VRAM, OAM and the palette are kept.
+func (p *PPU) Reset() {
+  p.ctrl = 0
+  p.mask = 0
+  p.rendering = false
+  p.t = 0
+  p.x = 0
+  p.w = false
+  p.buffer = 0
+}
+
+// Gets the console's timing.
+func (p *PPU) Region() *Region { return p.region }
+
+// Gets the scanline before the first visible one, which is the last one in the
+// frame.
+func (p *PPU) preRenderScanline() int {
+  return p.region.Scanlines - 1
+}
+
+// Gets whether or not the PPU is asserting the CPU's NMI line.
+//
+// The line goes low a few dots after vblank starts, which leaves a window in
+// which reading PPUSTATUS clears the flag before an NMI ever happens.
+func (p *PPU) NMI() bool {
+  if p.scanline == p.region.VblankScanline && p.dot < 4 {
+    return false
+  }
+  return p.status & STATUS_VBLANK != 0 && p.ctrl & CTRL_NMI != 0
+}
+
+// Gets the number of frames rendered since power on.
+func (p *PPU) FrameCount() uint64 { return p.frame }
+
+// Gets every pixel of the frame, one row after the other, as palette indices
+// along with the emphasis bits that were set when they were drawn.
+func (p *PPU) Framebuffer() []uint16 { return p.framebuffer[:] }
+
+// Gets whether or not either the background or the sprites are being rendered.
+func (p *PPU) renderingEnabled() bool {
+  return p.mask & (MASK_BACKGROUND | MASK_SPRITES) != 0
+}
+
+// Advances the PPU by a single dot.
+func (p *PPU) Step() {
+  visibleLine := p.scanline < FRAME_HEIGHT
+  preRenderLine := p.scanline == p.preRenderScanline()
+
+  if p.rendering && (visibleLine || preRenderLine) {
+    p.fetchBackground()
+    if visibleLine {
+      p.evaluateSprites()
+    }
+    p.fetchSprites()
+  }
+  if visibleLine && p.dot >= 1 && p.dot <= FRAME_WIDTH {
+    if p.scanline == 0 && p.dot == 1 {
+      p.framePhase = int(p.clock * NTSC_SAMPLES_PER_PIXEL % 12)
+    }
+    p.renderPixel()
+  }
+
+  if p.scanline == p.region.VblankScanline && p.dot == 1 {
+    if !p.suppressVblank {
+      p.status |= STATUS_VBLANK
+    }
+    p.suppressVblank = false
+  }
+  if preRenderLine && p.dot == 1 {
+    p.status &^= STATUS_VBLANK | STATUS_SPRITE_ZERO | STATUS_OVERFLOW
+  }
+
+  p.rendering = p.renderingEnabled()
+
+  p.clock++
+  p.dot++
+  // On odd frames with rendering on, NTSC PPUs skip the last dot of the
+  // pre-render scanline.
+  if preRenderLine && p.dot == DOTS_PER_SCANLINE - 1 && p.frame & 1 == 1 &&
+     p.rendering && p.region.SkipOddDot {
+    p.dot = DOTS_PER_SCANLINE
+  }
+  if p.dot == DOTS_PER_SCANLINE {
+    p.dot = 0
+    p.scanline++
+    if p.scanline == p.region.Scanlines {
+      p.scanline = 0
+      p.frame++
+    }
+  }
+}
+
+// Does the background work for the current dot: fetching tiles on the
+// hardware's schedule, feeding them into the shift registers, and moving the
+// VRAM address along.
+func (p *PPU) fetchBackground() {
+  if (p.dot >= 2 && p.dot <= 257) || (p.dot >= 321 && p.dot <= 337) {
+    p.patternShiftLow <<= 1
+    p.patternShiftHigh <<= 1
+    p.attributeShiftLow <<= 1
+    p.attributeShiftHigh <<= 1
+
+    switch (p.dot - 1) % 8 {
+    case 0:
+      p.reloadShifters()
+      p.nametableByte = p.readVRAM(0x2000 | p.v & 0x0FFF)
+    case 2:
+      address := 0x23C0 | p.v & 0x0C00 | (p.v >> 4) & 0x38 | (p.v >> 2) & 0x07
+      attribute := p.readVRAM(address)
+      if p.v & 0x0040 != 0 {
+        attribute >>= 4
+      }
+      if p.v & 0x0002 != 0 {
+        attribute >>= 2
+      }
+      p.attributeByte = attribute & 0x03
+    case 4:
+      p.patternLow = p.readVRAM(p.backgroundPatternAddress())
+    case 6:
+      p.patternHigh = p.readVRAM(p.backgroundPatternAddress() + 8)
+    case 7:
+      p.incrementX()
+    }
+  }
+
+  switch {
+  case p.dot == 256:
+    p.incrementY()
+  case p.dot == 257:
+    // Copy the horizontal bits of t over to v.
+    p.v = p.v & 0xFBE0 | p.t & 0x041F
+  case p.scanline == p.preRenderScanline() && p.dot >= 280 && p.dot <= 304:
+    // Copy the vertical bits of t over to v.
+    p.v = p.v & 0x841F | p.t & 0x7BE0
+  }
+}
+
+// Gets the address of the low plane of the row of the background tile being
+// fetched.
+func (p *PPU) backgroundPatternAddress() uint16 {
+  var table uint16 = 0
+  if p.ctrl & CTRL_BACKGROUND_TABLE != 0 {
+    table = 0x1000
+  }
+  fineY := (p.v >> 12) & 0x07
+  return table + uint16(p.nametableByte) * 16 + fineY
+}
+
+// Loads the tile fetched last into the low bytes of the shift registers.
+func (p *PPU) reloadShifters() {
+  p.patternShiftLow = p.patternShiftLow & 0xFF00 | uint16(p.patternLow)
+  p.patternShiftHigh = p.patternShiftHigh & 0xFF00 | uint16(p.patternHigh)
+  p.attributeShiftLow &= 0xFF00
+  p.attributeShiftHigh &= 0xFF00
+  if p.attributeByte & 0x01 != 0 {
+    p.attributeShiftLow |= 0x00FF
+  }
+  if p.attributeByte & 0x02 != 0 {
+    p.attributeShiftHigh |= 0x00FF
+  }
+}
+
+// Moves v along to the next tile horizontally, switching nametables when it
+// wraps around.
+func (p *PPU) incrementX() {
+  if p.v & 0x001F == 31 {
+    p.v &^= 0x001F
+    p.v ^= 0x0400
+  } else {
+    p.v++
+  }
+}
+
+// Moves v along to the next row of pixels, switching nametables when it gets
+// past the bottom of the nametable.
+func (p *PPU) incrementY() {
+  if p.v & 0x7000 != 0x7000 {
+    p.v += 0x1000
+    return
+  }
+  p.v &^= 0x7000
+  y := (p.v & 0x03E0) >> 5
+  switch y {
+  case 29:
+    y = 0
+    p.v ^= 0x0800
+  case 31:
+    y = 0
+  default:
+    y++
+  }
+  p.v = p.v & ^uint16(0x03E0) | y << 5
+}
+
+// Works out the background pixel at the current dot, out of the shift
+// registers.
+func (p *PPU) backgroundPixel() byte {
+  x := p.dot - 1
+  if p.mask & MASK_BACKGROUND == 0 || (x < 8 && p.mask & MASK_BACKGROUND_LEFT == 0) {
+    return 0
+  }
+  bit := uint16(0x8000) >> p.x
+  var pixel byte
+  if p.patternShiftLow & bit != 0 { pixel |= 0x01 }
+  if p.patternShiftHigh & bit != 0 { pixel |= 0x02 }
+  if pixel == 0 {
+    return 0
+  }
+  if p.attributeShiftLow & bit != 0 { pixel |= 0x04 }
+  if p.attributeShiftHigh & bit != 0 { pixel |= 0x08 }
+  return pixel
+}
+
+// Gets the height of sprites, which are either 8x8 or 8x16.
+func (p *PPU) spriteHeight() int {
+  if p.ctrl & CTRL_SPRITE_SIZE != 0 {
+    return 16
+  }
+  return 8
+}
+
+// Gets whether or not a sprite at the given Y coordinate shows up on the
+// scanline after the current one.
+func (p *PPU) spriteInRange(y byte) bool {
+  row := p.scanline - int(y)
+  return row >= 0 && row < p.spriteHeight()
+}
+
+// Moves sprite evaluation along to the next sprite in OAM.
+func (p *PPU) nextSprite() {
+  p.evaluationN++
+  if p.evaluationN == 64 {
+    p.evaluationN = 0
+    p.evaluationDone = true
+  }
+}
+
+// Does the sprite evaluation work for the current dot, on a visible scanline.
+//
+// Dots 1 to 64 clear secondary OAM, then dots 65 to 256 go through OAM looking
+// for the sprites that show up on the next scanline, reading on odd dots and
+// writing on even dots.
+func (p *PPU) evaluateSprites() {
+  switch {
+  case p.dot >= 1 && p.dot <= 64:
+    if p.dot % 2 == 0 {
+      p.secondaryOAM[p.dot / 2 - 1] = 0xFF
+    }
+    return
+  case p.dot == 65:
+    p.evaluationN = 0
+    p.evaluationM = 0
+    p.evaluationIndex = 0
+    p.evaluationDone = false
+    p.spriteZeroNext = false
+  case p.dot > 256:
+    return
+  }
+
+  if p.dot % 2 == 1 {
+    p.evaluationBuffer = p.oam[p.evaluationN * 4 + p.evaluationM]
+    return
+  }
+  if p.evaluationDone {
+    return
+  }
+
+  value := p.evaluationBuffer
+  if p.evaluationIndex < len(p.secondaryOAM) {
+    p.secondaryOAM[p.evaluationIndex] = value
+    if p.evaluationM == 0 {
+      if !p.spriteInRange(value) {
+        p.nextSprite()
+        return
+      }
+      if p.evaluationN == 0 {
+        p.spriteZeroNext = true
+      }
+    }
+    p.evaluationIndex++
+    p.evaluationM++
+    if p.evaluationM == 4 {
+      p.evaluationM = 0
+      p.nextSprite()
+    }
+    return
+  }
+
+  // With eight sprites found, the PPU keeps looking for a ninth to set the
+  // overflow flag. It's buggy though: it moves along to the next byte along
+  // with the next sprite, so it ends up scanning OAM diagonally, treating tile
+  // numbers, attributes and X coordinates as Y coordinates.
+  if p.spriteInRange(value) {
+    p.status |= STATUS_OVERFLOW
+    p.evaluationDone = true
+    return
+  }
+  p.evaluationM = (p.evaluationM + 1) & 3
+  p.nextSprite()
+}
+
+// Does the sprite fetching work for the current dot, which loads the patterns
+// of the sprites found by evaluation during dots 257 to 320.
+func (p *PPU) fetchSprites() {
+  if p.dot < 257 || p.dot > 320 {
+    return
+  }
+  p.oamAddr = 0
+
+  if p.dot == 257 {
+    p.spriteCount = 0
+    p.spriteZeroOnLine = false
+    if p.scanline != p.preRenderScanline() {
+      p.spriteCount = p.evaluationIndex / 4
+      p.spriteZeroOnLine = p.spriteZeroNext
+    }
+  }
+
+  // Each sprite takes eight dots; the pattern comes in at the end of them.
+  if (p.dot - 257) % 8 != 7 {
+    return
+  }
+  i := (p.dot - 257) / 8
+  if i >= p.spriteCount {
+    p.spritePatternLow[i] = 0
+    p.spritePatternHigh[i] = 0
+    return
+  }
+
+  y := p.secondaryOAM[i * 4]
+  tile := uint16(p.secondaryOAM[i * 4 + 1])
+  attributes := p.secondaryOAM[i * 4 + 2]
+  height := p.spriteHeight()
+
+  row := p.scanline - int(y)
+  if attributes & 0x80 != 0 {
+    row = height - 1 - row
+  }
+
+  var table uint16 = 0
+  if height == 16 {
+    table = (tile & 1) * 0x1000
+    tile &= 0xFE
+    if row >= 8 {
+      tile++
+      row -= 8
+    }
+  } else if p.ctrl & CTRL_SPRITE_TABLE != 0 {
+    table = 0x1000
+  }
+
+  address := table + tile * 16 + uint16(row)
+  low := p.readVRAM(address)
+  high := p.readVRAM(address + 8)
+  if attributes & 0x40 != 0 {
+    low = reverseBits(low)
+    high = reverseBits(high)
+  }
+
+  p.spritePatternLow[i] = low
+  p.spritePatternHigh[i] = high
+  p.spriteAttributes[i] = attributes
+  p.spriteX[i] = p.secondaryOAM[i * 4 + 3]
+}
+
+// Reverses the order of the bits in a byte, for horizontally flipped sprites.
+func reverseBits(value byte) byte {
+  var reversed byte
+  for i := 0; i < 8; i++ {
+    reversed = reversed << 1 | value & 1
+    value >>= 1
+  }
+  return reversed
+}
+
+// Works out the sprite pixel at the current dot, along with which of the
+// sprites on the scanline it came from. The first opaque sprite wins.
+func (p *PPU) spritePixel() (byte, int) {
+  x := p.dot - 1
+  if p.mask & MASK_SPRITES == 0 || (x < 8 && p.mask & MASK_SPRITES_LEFT == 0) {
+    return 0, -1
+  }
+  for i := 0; i < p.spriteCount; i++ {
+    offset := x - int(p.spriteX[i])
+    if offset < 0 || offset > 7 {
+      continue
+    }
+    bit := uint(7 - offset)
+    pixel := (p.spritePatternLow[i] >> bit) & 1 | ((p.spritePatternHigh[i] >> bit) & 1) << 1
+    if pixel != 0 {
+      return pixel | (p.spriteAttributes[i] & 0x03) << 2, i
+    }
+  }
+  return 0, -1
+}
+
+// Draws the pixel at the current dot into the framebuffer.
+func (p *PPU) renderPixel() {
+  x := p.dot - 1
+  background := p.backgroundPixel()
+  sprite, i := p.spritePixel()
+
+  var address uint16 = 0x3F00
+  switch {
+  case background == 0 && sprite == 0:
+  case background == 0:
+    address |= 0x10 | uint16(sprite)
+  case sprite == 0:
+    address |= uint16(background)
+  default:
+    if i == 0 && p.spriteZeroOnLine && x != 255 {
+      p.status |= STATUS_SPRITE_ZERO
+    }
+    if p.spriteAttributes[i] & 0x20 != 0 {
+      address |= uint16(background)
+    } else {
+      address |= 0x10 | uint16(sprite)
+    }
+  }
+
+  // With rendering off, the backdrop colour comes from wherever v points to if
+  // it's pointing into the palette.
+  if !p.rendering && p.v & 0x3F00 == 0x3F00 {
+    address = p.v
+  }
+
+  emphasis := uint16(p.mask & MASK_EMPHASIS) << 1
+  // PAL PPUs have red and green the other way around.
+  if p.region.SwapEmphasis {
+    emphasis = emphasis &^ 0x0C0 | emphasis & 0x040 << 1 | emphasis & 0x080 >> 1
+  }
+  p.framebuffer[p.scanline * FRAME_WIDTH + x] = uint16(p.readVRAM(address) & 0x3F) | emphasis
+}
+
+// Gets the value on the I/O latch, letting any bits that haven't been refreshed
+// in a while decay.
+func (p *PPU) openBus() byte {
+  for bit := uint(0); bit < 8; bit++ {
+    if p.clock - p.latchRefreshed[bit] > PPU_OPEN_BUS_DECAY {
+      p.latch &^= 1 << bit
+    }
+  }
+  return p.latch
+}
+
+// Drives the bits of the I/O latch selected by the mask with the given value.
+func (p *PPU) refreshLatch(value byte, mask byte) {
+  p.latch = p.openBus() &^ mask | value & mask
+  for bit := uint(0); bit < 8; bit++ {
+    if mask & (1 << bit) != 0 {
+      p.latchRefreshed[bit] = p.clock
+    }
+  }
+}
+
+// Works out where in the internal nametable memory an address between $2000
+// and $3EFF ends up, based on the cartridge's mirroring.
+func (p *PPU) nametableIndex(address uint16) uint16 {
+  address = (address - 0x2000) % 0x1000
+  table := address / 0x400
+  offset := address % 0x400
+  mirroring := MIRROR_HORIZONTAL
+  if p.mapper != nil {
+    mirroring = p.mapper.Mirroring()
+  }
+  switch mirroring {
+  case MIRROR_HORIZONTAL: table /= 2
+  case MIRROR_VERTICAL: table %= 2
+  case MIRROR_SINGLE_LOWER: table = 0
+  case MIRROR_SINGLE_UPPER: table = 1
+  }
+  return table * 0x400 + offset
+}
+
+// Reads from the PPU's own address space.
+func (p *PPU) readVRAM(address uint16) byte {
+  address &= 0x3FFF
+  switch {
+  case address < 0x2000:
+    if p.mapper == nil {
+      return 0
+    }
+    return p.mapper.ReadCHR(address)
+  case address < 0x3F00:
+    return p.nametables[p.nametableIndex(address)]
+  }
+  return p.readPalette(address)
+}
+
+// Works out where in palette RAM an address between $3F00 and $3FFF ends up.
+//
+// Palette RAM is mirrored every 32 bytes, and the first entry of each of the
+// sprite palettes is shared with the matching background palette.
+func paletteIndex(address uint16) uint16 {
+  index := address & 0x1F
+  if index >= 0x10 && index & 0x03 == 0 {
+    index -= 0x10
+  }
+  return index
+}
+
+// Reads an entry out of palette RAM, as seen through the grayscale bit.
+func (p *PPU) readPalette(address uint16) byte {
+  value := p.palette[paletteIndex(address)]
+  if p.mask & MASK_GRAYSCALE != 0 {
+    value &= 0x30
+  }
+  return value
+}
+
+// Writes to the PPU's own address space.
+func (p *PPU) writeVRAM(address uint16, value byte) {
+  address &= 0x3FFF
+  switch {
+  case address < 0x2000:
+    if p.mapper != nil {
+      p.mapper.WriteCHR(address, value)
+    }
+  case address < 0x3F00:
+    p.nametables[p.nametableIndex(address)] = value
+  default:
+    p.palette[paletteIndex(address)] = value & 0x3F
+  }
+}
+
+// Moves the VRAM address along after a $2007 access, by 1 or 32 depending on
+// PPUCTRL.
+func (p *PPU) incrementVRAMAddress() {
+  if p.ctrl & CTRL_INCREMENT != 0 {
+    p.v += 32
+  } else {
+    p.v++
+  }
+  p.v &= 0x7FFF
+}
+
+// Reads one of the registers mapped onto the CPU bus from $2000 to $3FFF.
+func (p *PPU) Read(address uint16) byte {
+  switch 0x2000 + address % 8 {
+  // PPUSTATUS
+  case 0x2002:
+    value := p.status & 0xE0 | p.openBus() & 0x1F
+    p.refreshLatch(value, 0xE0)
+    // Reading on the dot before vblank starts races with the flag being set:
+    // it reads as clear, and doesn't get set at all this frame.
+    if p.scanline == p.region.VblankScanline && p.dot == 1 {
+      p.suppressVblank = true
+    }
+    p.status &^= STATUS_VBLANK
+    p.w = false
+    return value
+
+  // OAMDATA
+  case 0x2004:
+    value := p.oam[p.oamAddr]
+    if p.oamAddr & 3 == 2 {
+      // Bits 2 to 4 of the sprite attributes aren't there.
+      value &= 0xE3
+    }
+    // Secondary OAM being cleared shows through as $FF.
+    if p.rendering && p.scanline < FRAME_HEIGHT && p.dot >= 1 && p.dot <= 64 {
+      value = 0xFF
+    }
+    p.refreshLatch(value, 0xFF)
+    return value
+
+  // PPUDATA
+  case 0x2007:
+    var value byte
+    address := p.v & 0x3FFF
+    if address >= 0x3F00 {
+      // Palette reads skip the buffer, though the buffer still gets filled
+      // with the nametable byte "underneath" the palette.
+      value = p.openBus() & 0xC0 | p.readVRAM(address) & 0x3F
+      p.refreshLatch(value, 0x3F)
+      p.buffer = p.readVRAM(address - 0x1000)
+    } else {
+      value = p.buffer
+      p.refreshLatch(value, 0xFF)
+      p.buffer = p.readVRAM(address)
+    }
+    p.incrementVRAMAddress()
+    return value
+  }
+
+  // The rest are write-only, so it's whatever is left on the latch.
+  return p.openBus()
+}
+
+// Writes to one of the registers mapped onto the CPU bus from $2000 to $3FFF.
+func (p *PPU) Write(address uint16, value byte) {
+  p.refreshLatch(value, 0xFF)
+
+  switch 0x2000 + address % 8 {
+  // PPUCTRL
+  case 0x2000:
+    p.ctrl = value
+    p.t = p.t & 0xF3FF | uint16(value & CTRL_NAMETABLE) << 10
+
+  // PPUMASK
+  case 0x2001:
+    p.mask = value
+
+  // OAMADDR
+  case 0x2003:
+    p.oamAddr = value
+
+  // OAMDATA
+  case 0x2004:
+    p.oam[p.oamAddr] = value
+    p.oamAddr++
+
+  // PPUSCROLL
+  case 0x2005:
+    if !p.w {
+      p.t = p.t & 0xFFE0 | uint16(value) >> 3
+      p.x = value & 0x07
+    } else {
+      p.t = p.t & 0x8C1F | uint16(value & 0x07) << 12 | uint16(value & 0xF8) << 2
+    }
+    p.w = !p.w
+
+  // PPUADDR
+  case 0x2006:
+    if !p.w {
+      p.t = p.t & 0x80FF | uint16(value & 0x3F) << 8
+    } else {
+      p.t = p.t & 0xFF00 | uint16(value)
+      p.v = p.t
+    }
+    p.w = !p.w
+
+  // PPUDATA
+  case 0x2007:
+    p.writeVRAM(p.v, value)
+    p.incrementVRAMAddress()
+  }
+}
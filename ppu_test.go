@@ -0,0 +1,648 @@
+package main
+
+import "testing"
+import "log"
+
+// Initializes a PPU over an NROM board with CHR RAM.
+func initPPU() *PPU {
+  rom := make([]byte, 16 + PRG_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x01\x00\x01\x00"))
+  cart, _ := LoadCartridge(rom)
+  return PPUNew(NROMNew(cart))
+}
+
+func TestPPUAddressToggle(t *testing.T) {
+  ppu := initPPU()
+
+  ppu.Write(0x2006, 0x21)
+  ppu.Write(0x2006, 0x08)
+  if ppu.v != 0x2108 {
+    log.Printf("Expecting v to be 0x2108, but got %X", ppu.v)
+    t.Fail()
+  }
+
+  // A half-finished write gets reset by reading PPUSTATUS.
+  ppu.Write(0x2006, 0x3F)
+  ppu.Read(0x2002)
+  ppu.Write(0x2006, 0x23)
+  ppu.Write(0x2006, 0xC0)
+  if ppu.v != 0x23C0 {
+    log.Printf("Expecting v to be 0x23C0, but got %X", ppu.v)
+    t.Fail()
+  }
+
+  // $2005 and $2006 share the toggle.
+  ppu.Write(0x2005, 0x7D)
+  if ppu.x != 0x05 || ppu.t & 0x001F != 0x0F {
+    log.Printf("Expecting coarse X 0x0F and fine X 5, but got %X and %d", ppu.t & 0x1F, ppu.x)
+    t.Fail()
+  }
+  ppu.Write(0x2006, 0x00)
+  if ppu.v != 0x2300 {
+    log.Printf("Expecting the second write to have gone to PPUADDR, but v is %X", ppu.v)
+    t.Fail()
+  }
+}
+
+func TestPPUDataBuffer(t *testing.T) {
+  ppu := initPPU()
+
+  ppu.Write(0x2006, 0x20)
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2007, 0x11)
+  ppu.Write(0x2007, 0x22)
+
+  ppu.Write(0x2006, 0x20)
+  ppu.Write(0x2006, 0x00)
+  ppu.Read(0x2007)
+  if value := ppu.Read(0x2007); value != 0x11 {
+    log.Printf("Expecting buffered read to return 0x11, but got %X", value)
+    t.Fail()
+  }
+  if value := ppu.Read(0x2007); value != 0x22 {
+    log.Printf("Expecting buffered read to return 0x22, but got %X", value)
+    t.Fail()
+  }
+
+  // Palette reads aren't buffered.
+  ppu.Write(0x2006, 0x3F)
+  ppu.Write(0x2006, 0x01)
+  ppu.Write(0x2007, 0x2A)
+  ppu.Write(0x2006, 0x3F)
+  ppu.Write(0x2006, 0x01)
+  if value := ppu.Read(0x2007) & 0x3F; value != 0x2A {
+    log.Printf("Expecting palette read to return 0x2A, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestPPUIncrement(t *testing.T) {
+  ppu := initPPU()
+  ppu.Write(0x2000, CTRL_INCREMENT)
+  ppu.Write(0x2006, 0x20)
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2007, 0xFF)
+  if ppu.v != 0x2020 {
+    log.Printf("Expecting v to go up by 32, but got %X", ppu.v)
+    t.Fail()
+  }
+  ppu.Write(0x2000, 0)
+  ppu.Write(0x2007, 0xFF)
+  if ppu.v != 0x2021 {
+    log.Printf("Expecting v to go up by 1, but got %X", ppu.v)
+    t.Fail()
+  }
+}
+
+func TestPPUStatus(t *testing.T) {
+  ppu := initPPU()
+  ppu.status = STATUS_VBLANK | STATUS_SPRITE_ZERO
+  ppu.Write(0x2000, 0x1F)
+
+  value := ppu.Read(0x2002)
+  if value != STATUS_VBLANK | STATUS_SPRITE_ZERO | 0x1F {
+    log.Printf("Expecting status with open bus low bits, but got %X", value)
+    t.Fail()
+  }
+  if ppu.Read(0x2002) & STATUS_VBLANK != 0 {
+    log.Printf("Expecting the vblank flag to be cleared by reading it")
+    t.Fail()
+  }
+}
+
+func TestPPUOpenBus(t *testing.T) {
+  ppu := initPPU()
+  cpu := CPUNew()
+  cpu.Map(0x2000, 0x3FFF, ppu)
+
+  // Registers are mirrored every 8 bytes.
+  cpu.write(0x3FF8, 0xA5)
+  if ppu.ctrl != 0xA5 {
+    log.Printf("Expecting a write to $3FF8 to land on PPUCTRL")
+    t.Fail()
+  }
+  if value := cpu.read(0x2000); value != 0xA5 {
+    log.Printf("Expecting write-only register to read back the latch, but got %X", value)
+    t.Fail()
+  }
+
+  ppu.clock += PPU_OPEN_BUS_DECAY + 1
+  if value := cpu.read(0x2005); value != 0 {
+    log.Printf("Expecting the latch to have decayed, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestPPURenderBackground(t *testing.T) {
+  ppu := initPPU()
+
+  // Tile 1: its top row is colour 3, the rest is colour 1.
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2006, 0x10)
+  for i := 0; i < 16; i++ {
+    if i <= 8 {
+      ppu.Write(0x2007, 0xFF)
+    } else {
+      ppu.Write(0x2007, 0x00)
+    }
+  }
+
+  // Put tile 1 at the second tile of the first row, with palette 1.
+  ppu.Write(0x2006, 0x20)
+  ppu.Write(0x2006, 0x01)
+  ppu.Write(0x2007, 0x01)
+  ppu.Write(0x2006, 0x23)
+  ppu.Write(0x2006, 0xC0)
+  ppu.Write(0x2007, 0x01)
+
+  ppu.Write(0x2006, 0x3F)
+  ppu.Write(0x2006, 0x00)
+  for _, colour := range []byte{0x0F, 0x01, 0x02, 0x03, 0x0F, 0x11, 0x12, 0x13} {
+    ppu.Write(0x2007, colour)
+  }
+
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2005, 0x00)
+  ppu.Write(0x2005, 0x00)
+  ppu.Write(0x2001, MASK_BACKGROUND | MASK_BACKGROUND_LEFT)
+
+  // Run the rest of this frame so that v gets set up by the pre-render line,
+  // then a whole frame.
+  for ppu.FrameCount() < 2 {
+    ppu.Step()
+  }
+
+  frame := ppu.Framebuffer()
+  if frame[0] != 0x0F {
+    log.Printf("Expecting the backdrop at (0, 0), but got %X", frame[0])
+    t.Fail()
+  }
+  if frame[8] != 0x13 {
+    log.Printf("Expecting colour 0x13 at (8, 0), but got %X", frame[8])
+    t.Fail()
+  }
+  if frame[FRAME_WIDTH + 15] != 0x11 {
+    log.Printf("Expecting colour 0x11 at (15, 1), but got %X", frame[FRAME_WIDTH + 15])
+    t.Fail()
+  }
+  if frame[FRAME_WIDTH + 16] != 0x0F {
+    log.Printf("Expecting the backdrop at (16, 1), but got %X", frame[FRAME_WIDTH + 16])
+    t.Fail()
+  }
+
+  // Fine X scrolling shifts everything left.
+  ppu.Write(0x2005, 0x03)
+  ppu.Write(0x2005, 0x00)
+  for ppu.FrameCount() < 4 {
+    ppu.Step()
+  }
+  if frame[FRAME_WIDTH + 5] != 0x11 || frame[FRAME_WIDTH + 4] != 0x0F {
+    log.Printf("Expecting the tile to start at (5, 1) when scrolled by 3")
+    t.Fail()
+  }
+}
+
+func TestPPUNMI(t *testing.T) {
+  program := make([]byte, 0x7000)
+  for i := range program {
+    program[i] = 0xEA
+  }
+  instructions := ConvertSimpleInstructions(program)
+  // NMI vector
+  instructions[0x7FFA] = 0x00
+  instructions[0x7FFB] = 0xF0
+
+  cpu := CPUNew()
+  cpu.SetInstructions(instructions)
+  cpu.MovePCToResetVector()
+  ppu := initPPU()
+  scheduler := SchedulerNew(RegionNTSC)
+  scheduler.Add(RegionNTSC.PPUDivider, ppu.Step)
+  cpu.Connect(scheduler)
+  cpu.ConnectNMI(ppu)
+  ppu.Write(0x2000, CTRL_NMI)
+
+  for i := 0; i < 20000 && cpu.pc != 0xF000; i++ {
+    cpu.Step()
+  }
+  if cpu.pc != 0xF000 {
+    log.Printf("Expecting the NMI handler to have been jumped to")
+    t.FailNow()
+  }
+  if ppu.scanline != VBLANK_SCANLINE {
+    log.Printf("Expecting the NMI to come in on scanline %d, but got %d", VBLANK_SCANLINE, ppu.scanline)
+    t.Fail()
+  }
+  if !cpu.I() {
+    log.Printf("Expecting the interrupt disable flag to be set")
+    t.Fail()
+  }
+  if cpu.sp != 0xFD - 3 {
+    log.Printf("Expecting three bytes to have been pushed, but the stack pointer is %X", cpu.sp)
+    t.Fail()
+  }
+}
+
+// Sets up tile 1 as a solid block of colour 1, and puts it at the top left of
+// the screen in the background.
+func initPPUWithSolidTile() *PPU {
+  ppu := initPPU()
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2006, 0x10)
+  for i := 0; i < 8; i++ {
+    ppu.Write(0x2007, 0xFF)
+  }
+  ppu.Write(0x2006, 0x20)
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2007, 0x01)
+  for i := range ppu.oam {
+    ppu.oam[i] = 0xFF
+  }
+  ppu.Write(0x2006, 0x00)
+  ppu.Write(0x2006, 0x00)
+  return ppu
+}
+
+func TestPPUSpriteZeroHit(t *testing.T) {
+  ppu := initPPUWithSolidTile()
+  // Sprite 0 at (4, 3), overlapping the background tile from (4, 4).
+  copy(ppu.oam[0:], []byte{3, 0x01, 0x00, 4})
+  ppu.Write(0x2001, MASK_BACKGROUND | MASK_SPRITES | MASK_BACKGROUND_LEFT | MASK_SPRITES_LEFT)
+
+  for ppu.FrameCount() < 1 {
+    ppu.Step()
+  }
+  for ppu.scanline < 4 || ppu.dot <= 4 {
+    if ppu.status & STATUS_SPRITE_ZERO != 0 {
+      log.Printf("Expecting no sprite 0 hit before (4, 4), but got one at (%d, %d)", ppu.dot - 1, ppu.scanline)
+      t.FailNow()
+    }
+    ppu.Step()
+  }
+  ppu.Step()
+  if ppu.status & STATUS_SPRITE_ZERO == 0 {
+    log.Printf("Expecting a sprite 0 hit at (4, 4)")
+    t.Fail()
+  }
+
+  // Clipping the left 8 pixels of sprites hides the hit.
+  ppu.Write(0x2001, MASK_BACKGROUND | MASK_SPRITES | MASK_BACKGROUND_LEFT)
+  for ppu.FrameCount() < 2 {
+    ppu.Step()
+  }
+  for ppu.FrameCount() < 3 {
+    ppu.Step()
+    if ppu.status & STATUS_SPRITE_ZERO != 0 {
+      log.Printf("Expecting no sprite 0 hit with sprites clipped")
+      t.FailNow()
+    }
+  }
+}
+
+func TestPPUSpriteOverflow(t *testing.T) {
+  ppu := initPPUWithSolidTile()
+  for i := 0; i < 8; i++ {
+    copy(ppu.oam[i * 4:], []byte{100, 0x01, 0x00, byte(i * 8)})
+  }
+  ppu.Write(0x2001, MASK_SPRITES)
+  for ppu.FrameCount() < 2 {
+    ppu.Step()
+    if ppu.status & STATUS_OVERFLOW != 0 {
+      log.Printf("Expecting no overflow with eight sprites on a line")
+      t.FailNow()
+    }
+  }
+
+  copy(ppu.oam[8 * 4:], []byte{100, 0x01, 0x00, 64})
+  ppu.palette[0x11] = 0x2A
+  for ppu.scanline != 101 {
+    ppu.Step()
+  }
+  if ppu.status & STATUS_OVERFLOW == 0 {
+    log.Printf("Expecting overflow with nine sprites on a line")
+    t.Fail()
+  }
+  for ppu.scanline != 102 {
+    ppu.Step()
+  }
+  if value := ppu.framebuffer[101 * FRAME_WIDTH + 60]; value != uint16(ppu.palette[0x11]) {
+    log.Printf("Expecting the eighth sprite to be drawn, but got %X", value)
+    t.Fail()
+  }
+  if value := ppu.framebuffer[101 * FRAME_WIDTH + 66]; value != uint16(ppu.palette[0]) {
+    log.Printf("Expecting the ninth sprite not to be drawn, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestPPUPalette(t *testing.T) {
+  ppu := initPPU()
+
+  // $3F10 is a mirror of $3F00, but $3F11 isn't one of $3F01.
+  ppu.Write(0x2006, 0x3F)
+  ppu.Write(0x2006, 0x10)
+  ppu.Write(0x2007, 0x21)
+  ppu.Write(0x2007, 0x22)
+  if ppu.palette[0x00] != 0x21 || ppu.palette[0x01] != 0x00 || ppu.palette[0x11] != 0x22 {
+    log.Printf("Expecting $3F10 to mirror $3F00, and $3F11 not to mirror $3F01")
+    t.Fail()
+  }
+
+  // Palette RAM is mirrored all the way up to $3FFF.
+  ppu.Write(0x2006, 0x3F)
+  ppu.Write(0x2006, 0xE0)
+  if value := ppu.Read(0x2007) & 0x3F; value != 0x21 {
+    log.Printf("Expecting $3FE0 to mirror $3F00, but got %X", value)
+    t.Fail()
+  }
+
+  ppu.Write(0x2001, MASK_GRAYSCALE)
+  ppu.Write(0x2006, 0x3F)
+  ppu.Write(0x2006, 0x11)
+  if value := ppu.Read(0x2007) & 0x3F; value != 0x20 {
+    log.Printf("Expecting grayscale to mask the colour down to 0x20, but got %X", value)
+    t.Fail()
+  }
+}
+
+func TestPPUImage(t *testing.T) {
+  ppu := initPPU()
+  ppu.palette[0] = 0x16
+  ppu.Write(0x2001, 0x20)
+  for ppu.FrameCount() < 1 {
+    ppu.Step()
+  }
+
+  if ppu.framebuffer[0] != 0x16 | 0x40 {
+    log.Printf("Expecting the red emphasis bit to be in the framebuffer, but got %X", ppu.framebuffer[0])
+    t.Fail()
+  }
+
+  img := ppu.Image(nil)
+  if img.Bounds().Dx() != FRAME_WIDTH || img.Bounds().Dy() != FRAME_HEIGHT {
+    log.Printf("Expecting a 256x240 image")
+    t.Fail()
+  }
+  c := img.RGBAAt(0, 0)
+  plain := DefaultPalette.Color(0x16)
+  if c.R != plain.R || c.G >= plain.G || c.B >= plain.B {
+    log.Printf("Expecting red emphasis to dim green and blue, but got %v from %v", c, plain)
+    t.Fail()
+  }
+}
+
+func TestLoadPalette(t *testing.T) {
+  data := make([]byte, 64 * 3)
+  data[0x21 * 3] = 0x12
+  data[0x21 * 3 + 1] = 0x34
+  data[0x21 * 3 + 2] = 0x56
+  palette, err := LoadPalette(data)
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if c := palette.Color(0x21); c.R != 0x12 || c.G != 0x34 || c.B != 0x56 {
+    log.Printf("Expecting colour 0x21 to be #123456, but got %v", c)
+    t.Fail()
+  }
+
+  data = make([]byte, 512 * 3)
+  data[0x1E1 * 3] = 0xAB
+  palette, err = LoadPalette(data)
+  if err != nil || palette.Color(0x1E1).R != 0xAB {
+    log.Printf("Expecting the emphasis variants to come from the file")
+    t.Fail()
+  }
+
+  if _, err := LoadPalette(make([]byte, 100)); err == nil {
+    log.Printf("Expecting a palette of the wrong size to fail")
+    t.Fail()
+  }
+}
+
+func TestBuiltinPalettes(t *testing.T) {
+  for name, palette := range BuiltinPalettes {
+    white := palette.Color(0x30)
+    black := palette.Color(0x0F)
+    if white.R < 0xC0 || white.G < 0xC0 || white.B < 0xC0 {
+      log.Printf("Expecting colour 0x30 of the %s palette to be white, but got %v", name, white)
+      t.Fail()
+    }
+    if black.R > 0x20 || black.G > 0x20 || black.B > 0x20 {
+      log.Printf("Expecting colour 0x0F of the %s palette to be black, but got %v", name, black)
+      t.Fail()
+    }
+  }
+
+  // Colour 0x16 is a red.
+  red := PaletteComposite.Color(0x16)
+  if red.R <= red.G || red.R <= red.B {
+    log.Printf("Expecting colour 0x16 of the composite palette to be red, but got %v", red)
+    t.Fail()
+  }
+}
+
+func TestNTSCFilter(t *testing.T) {
+  frame := make([]uint16, FRAME_WIDTH * FRAME_HEIGHT)
+  for i := range frame {
+    // Alternating columns of white and black, which is what makes for colour
+    // artifacts.
+    if i % 2 == 0 {
+      frame[i] = 0x30
+    } else {
+      frame[i] = 0x0F
+    }
+  }
+
+  filter := NTSCFilterNew()
+  img := filter.Apply(frame, 0)
+  if img.Bounds().Dx() != NTSC_WIDTH || img.Bounds().Dy() != FRAME_HEIGHT {
+    log.Printf("Expecting a %dx%d image", NTSC_WIDTH, FRAME_HEIGHT)
+    t.Fail()
+  }
+
+  // The colour artifacts change along with the phase.
+  other := filter.Apply(frame, 4)
+  if img.RGBAAt(300, 0) == other.RGBAAt(300, 0) {
+    log.Printf("Expecting the picture to crawl as the phase changes")
+    t.Fail()
+  }
+
+  // Without artifacts, it's just the palette colours.
+  filter.Artifacts = 0
+  clean := filter.Apply(frame, 0)
+  white := GenerateCompositePalette(0, 1, 1).Color(0x30)
+  if clean.RGBAAt(0, 0) != white {
+    log.Printf("Expecting white at (0, 0), but got %v", clean.RGBAAt(0, 0))
+    t.Fail()
+  }
+
+  // Changing the hue changes the palette along with it.
+  filter.SetHue(90)
+  red := GenerateCompositePalette(90, 1, 1).Color(0x16)
+  if filter.palette.Color(0x16) != red || filter.Hue() != 90 {
+    log.Printf("Expecting the palette to follow the hue")
+    t.Fail()
+  }
+}
+
+func TestPPURegions(t *testing.T) {
+  // PAL gets 16 dots out of every 5 CPU cycles.
+  ppu := initPPU()
+  ppu.SetRegion(RegionPAL)
+  scheduler := SchedulerNew(RegionPAL)
+  scheduler.Add(RegionPAL.PPUDivider, ppu.Step)
+  for i := 0; i < 5; i++ {
+    scheduler.Clock()
+  }
+  if ppu.dot != 16 {
+    log.Printf("Expecting 16 dots after 5 PAL CPU cycles, but got %d", ppu.dot)
+    t.Fail()
+  }
+
+  // And a frame runs for 312 scanlines.
+  for ppu.FrameCount() == 0 {
+    ppu.Step()
+  }
+  if ppu.clock != 312 * DOTS_PER_SCANLINE {
+    log.Printf("Expecting a PAL frame to take %d dots, but took %d", 312 * DOTS_PER_SCANLINE, ppu.clock)
+    t.Fail()
+  }
+
+  // The red and green emphasis bits are the other way around.
+  ppu.Write(0x2001, 0x20)
+  ppu.Step()
+  ppu.Step()
+  if emphasis := ppu.Framebuffer()[0] >> 6; emphasis != 0x02 {
+    log.Printf("Expecting the PAL red emphasis bit to emphasise green, but got %X", emphasis)
+    t.Fail()
+  }
+
+  // The Dendy starts vblank late.
+  ppu = initPPU()
+  ppu.SetRegion(RegionDendy)
+  scheduler = SchedulerNew(RegionDendy)
+  scheduler.Add(RegionDendy.PPUDivider, ppu.Step)
+  for ppu.status & STATUS_VBLANK == 0 {
+    scheduler.Clock()
+  }
+  if ppu.scanline != 291 {
+    log.Printf("Expecting the Dendy's vblank to start on scanline 291, but got %d", ppu.scanline)
+    t.Fail()
+  }
+
+  // The region comes out of the NES 2.0 header.
+  rom := make([]byte, 16 + PRG_BANK_SIZE)
+  copy(rom, []byte("NES\x1A\x01\x00\x01\x08"))
+  rom[12] = TIMING_DENDY
+  cart, _ := LoadCartridge(rom)
+  if cart.Region() != RegionDendy {
+    log.Printf("Expecting the Dendy region out of the NES 2.0 header")
+    t.Fail()
+  }
+}
+
+func TestPPUOddFrameSkip(t *testing.T) {
+  ppu := initPPU()
+  ppu.Write(0x2001, MASK_BACKGROUND)
+
+  // Frame 0 is even, so it runs the full length.
+  for ppu.FrameCount() == 0 {
+    ppu.Step()
+  }
+  if ppu.clock != SCANLINES_PER_FRAME * DOTS_PER_SCANLINE {
+    log.Printf("Expecting an even frame to take %d dots, but took %d", SCANLINES_PER_FRAME * DOTS_PER_SCANLINE, ppu.clock)
+    t.Fail()
+  }
+  start := ppu.clock
+  for ppu.FrameCount() == 1 {
+    ppu.Step()
+  }
+  if ppu.clock - start != SCANLINES_PER_FRAME * DOTS_PER_SCANLINE - 1 {
+    log.Printf("Expecting an odd frame to be a dot short, but took %d", ppu.clock - start)
+    t.Fail()
+  }
+
+  // With rendering off, nothing gets skipped.
+  ppu.Write(0x2001, 0)
+  for ppu.FrameCount() == 2 {
+    ppu.Step()
+  }
+  start = ppu.clock
+  for ppu.FrameCount() == 3 {
+    ppu.Step()
+  }
+  if ppu.clock - start != SCANLINES_PER_FRAME * DOTS_PER_SCANLINE {
+    log.Printf("Expecting no dot to be skipped with rendering off, but took %d", ppu.clock - start)
+    t.Fail()
+  }
+}
+
+func TestPPUVblankRace(t *testing.T) {
+  stepTo := func(ppu *PPU, scanline int, dot int) {
+    for ppu.scanline != scanline || ppu.dot != dot {
+      ppu.Step()
+    }
+  }
+
+  // Reading a dot before vblank starts stops the flag from being set.
+  ppu := initPPU()
+  ppu.Write(0x2000, CTRL_NMI)
+  stepTo(ppu, VBLANK_SCANLINE, 1)
+  if ppu.Read(0x2002) & STATUS_VBLANK != 0 {
+    log.Printf("Expecting the vblank flag to read as clear just before it's set")
+    t.Fail()
+  }
+  stepTo(ppu, VBLANK_SCANLINE, 10)
+  if ppu.status & STATUS_VBLANK != 0 || ppu.NMI() {
+    log.Printf("Expecting vblank and the NMI to be suppressed")
+    t.Fail()
+  }
+
+  // Reading just after it's set sees it, but the NMI never happens.
+  ppu = initPPU()
+  ppu.Write(0x2000, CTRL_NMI)
+  stepTo(ppu, VBLANK_SCANLINE, 2)
+  if ppu.NMI() {
+    log.Printf("Expecting the NMI to be held off for a few dots")
+    t.Fail()
+  }
+  if ppu.Read(0x2002) & STATUS_VBLANK == 0 {
+    log.Printf("Expecting the vblank flag to read as set just after it's set")
+    t.Fail()
+  }
+  stepTo(ppu, VBLANK_SCANLINE, 10)
+  if ppu.NMI() {
+    log.Printf("Expecting the NMI to be suppressed")
+    t.Fail()
+  }
+
+  // Otherwise, the NMI happens a few dots in.
+  ppu = initPPU()
+  ppu.Write(0x2000, CTRL_NMI)
+  stepTo(ppu, VBLANK_SCANLINE, 4)
+  if !ppu.NMI() {
+    log.Printf("Expecting an NMI")
+    t.Fail()
+  }
+}
+
+func TestPPURenderingToggleDelay(t *testing.T) {
+  ppu := initPPU()
+  for ppu.scanline != 10 || ppu.dot != 100 {
+    ppu.Step()
+  }
+
+  // Turning rendering on only reaches the pipeline after a dot.
+  ppu.Write(0x2001, MASK_BACKGROUND)
+  if ppu.rendering {
+    log.Printf("Expecting rendering to be enabled a dot later")
+    t.Fail()
+  }
+  ppu.Step()
+  if !ppu.rendering {
+    log.Printf("Expecting rendering to be enabled")
+    t.Fail()
+  }
+}
@@ -0,0 +1,131 @@
+package main
+
+// The TV systems a ROM can say it was made for, as given by the NES 2.0 header.
+const (
+  TIMING_NTSC byte = iota
+  TIMING_PAL
+  // Works on both NTSC and PAL consoles.
+  TIMING_MULTIPLE
+  TIMING_DENDY
+)
+
+// Represents the timing of one of the consoles sold around the world: how fast
+// its master clock runs, how the CPU and PPU clocks are divided off of it, and
+// the tables that depend on them.
+type Region struct {
+  Name string
+
+  // The master clock, in Hz, and how many master clock cycles it takes for a
+  // CPU cycle and a PPU dot.
+  MasterClock int
+  CPUDivider, PPUDivider int
+
+  // How many scanlines there are in a frame, and the one vblank starts on.
+  Scanlines int
+  VblankScanline int
+
+  // Whether a dot gets skipped on odd frames while rendering is enabled.
+  SkipOddDot bool
+  // Whether the red and green emphasis bits in PPUMASK are the other way
+  // around.
+  SwapEmphasis bool
+
+  // The APU's noise and DMC timer periods, in CPU cycles.
+  NoisePeriods [16]uint16
+  DMCPeriods [16]uint16
+
+  // The CPU cycles at which the APU frame counter's steps land, in 4-step and
+  // 5-step mode. The last three steps of each are the ones around where the
+  // sequence wraps.
+  FourStepCycles, FiveStepCycles [6]int
+}
+
+var ntscNoisePeriods = [16]uint16{
+  4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+var ntscDMCPeriods = [16]uint16{
+  428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+var ntscFourStepCycles = [6]int{7457, 14913, 22371, 29828, 29829, 29830}
+var ntscFiveStepCycles = [6]int{7457, 14913, 22371, 29829, 37281, 37282}
+
+// North American and Japanese consoles.
+var RegionNTSC = &Region{
+  Name: "NTSC",
+  MasterClock: 21477272,
+  CPUDivider: 12,
+  PPUDivider: 4,
+  Scanlines: SCANLINES_PER_FRAME,
+  VblankScanline: VBLANK_SCANLINE,
+  SkipOddDot: true,
+  NoisePeriods: ntscNoisePeriods,
+  DMCPeriods: ntscDMCPeriods,
+  FourStepCycles: ntscFourStepCycles,
+  FiveStepCycles: ntscFiveStepCycles,
+}
+
+// European and Australian consoles, whose CPU runs 3.2 PPU dots to a cycle.
+var RegionPAL = &Region{
+  Name: "PAL",
+  MasterClock: 26601712,
+  CPUDivider: 16,
+  PPUDivider: 5,
+  Scanlines: 312,
+  VblankScanline: 241,
+  SwapEmphasis: true,
+  NoisePeriods: [16]uint16{
+    4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778,
+  },
+  DMCPeriods: [16]uint16{
+    398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50,
+  },
+  FourStepCycles: [6]int{8313, 16627, 24939, 33252, 33253, 33254},
+  FiveStepCycles: [6]int{8313, 16627, 24939, 33253, 41565, 41566},
+}
+
+// The Dendy and other famiclones, which run PAL's master clock and scanline
+// count with a CPU divided down so that it gets 3 dots to a cycle like NTSC,
+// and hold off vblank until late in the frame so that NTSC games run close to
+// the right speed. The APU keeps NTSC's tables.
+var RegionDendy = &Region{
+  Name: "Dendy",
+  MasterClock: 26601712,
+  CPUDivider: 15,
+  PPUDivider: 5,
+  Scanlines: 312,
+  VblankScanline: 291,
+  SwapEmphasis: true,
+  NoisePeriods: ntscNoisePeriods,
+  DMCPeriods: ntscDMCPeriods,
+  FourStepCycles: ntscFourStepCycles,
+  FiveStepCycles: ntscFiveStepCycles,
+}
+
+// The regions, by name.
+var Regions = map[string]*Region{
+  "ntsc": RegionNTSC,
+  "pal": RegionPAL,
+  "dendy": RegionDendy,
+}
+
+// Gets the region that goes with a TV system out of a ROM's header. ROMs that
+// work on either run as NTSC.
+func RegionForTiming(timing byte) *Region {
+  switch timing {
+  case TIMING_PAL: return RegionPAL
+  case TIMING_DENDY: return RegionDendy
+  }
+  return RegionNTSC
+}
+
+// Gets how many times a second the CPU gets clocked.
+func (r *Region) CPUClockRate() float64 {
+  return float64(r.MasterClock) / float64(r.CPUDivider)
+}
+
+// Gets how many frames a second the PPU puts out, leaving aside skipped dots.
+func (r *Region) FrameRate() float64 {
+  return float64(r.MasterClock) / float64(r.PPUDivider * DOTS_PER_SCANLINE * r.Scanlines)
+}
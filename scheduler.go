@@ -0,0 +1,66 @@
+package main
+
+// Runs components off the console's master clock, each one every so many
+// master clock cycles, in the order those cycles come up.
+//
+// It's clocked by the CPU, which is itself run off the master clock by its
+// divider, so everything it runs stays lined up with the CPU's bus accesses.
+type Scheduler struct {
+  region *Region
+  // The number of master clock cycles gone by since power on.
+  clock uint64
+  components []*scheduled
+}
+
+// A component run by the scheduler, and the master clock cycle it's next due
+// on.
+type scheduled struct {
+  divider int
+  next uint64
+  tick func()
+}
+
+// Initializes a new scheduler, with the given timing.
+func SchedulerNew(region *Region) *Scheduler {
+  return &Scheduler{region: region}
+}
+
+// Has a function called once every divider master clock cycles, starting
+// divider cycles from now. Components due on the same cycle get run in the
+// order they were added.
+func (s *Scheduler) Add(divider int, tick func()) {
+  s.components = append(s.components, &scheduled{
+    divider: divider,
+    next: s.clock + uint64(divider),
+    tick: tick,
+  })
+}
+
+// Gets the number of master clock cycles gone by since power on.
+func (s *Scheduler) MasterClock() uint64 { return s.clock }
+
+// Runs the master clock for the given number of cycles, running everything
+// that comes due along the way.
+func (s *Scheduler) Advance(cycles int) {
+  end := s.clock + uint64(cycles)
+  for {
+    var due *scheduled
+    for _, component := range s.components {
+      if component.next <= end && (due == nil || component.next < due.next) {
+        due = component
+      }
+    }
+    if due == nil {
+      break
+    }
+    s.clock = due.next
+    due.next += uint64(due.divider)
+    due.tick()
+  }
+  s.clock = end
+}
+
+// Runs the master clock for a CPU cycle.
+func (s *Scheduler) Clock() {
+  s.Advance(s.region.CPUDivider)
+}
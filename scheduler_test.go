@@ -0,0 +1,100 @@
+package main
+
+import "log"
+import "testing"
+
+func TestSchedulerDividers(t *testing.T) {
+  for _, region := range []*Region{RegionNTSC, RegionPAL} {
+    scheduler := SchedulerNew(region)
+    var dots, cycles int
+    scheduler.Add(region.PPUDivider, func() { dots++ })
+    scheduler.Add(region.CPUDivider, func() { cycles++ })
+    // 5 CPU cycles is 15 dots on NTSC, and 16 on PAL.
+    for i := 0; i < 5; i++ {
+      scheduler.Clock()
+    }
+    expected := 5 * region.CPUDivider / region.PPUDivider
+    if dots != expected || cycles != 5 {
+      log.Printf("Expecting %d dots in 5 %s cycles, but got %d", expected, region.Name, dots)
+      t.Fail()
+    }
+    if scheduler.MasterClock() != uint64(5 * region.CPUDivider) {
+      log.Printf("Expecting the master clock at %d, but got %d", 5 * region.CPUDivider, scheduler.MasterClock())
+      t.Fail()
+    }
+  }
+}
+
+func TestSchedulerOrder(t *testing.T) {
+  scheduler := SchedulerNew(RegionNTSC)
+  var order []int
+  scheduler.Add(3, func() { order = append(order, 3) })
+  scheduler.Add(2, func() { order = append(order, 2) })
+  scheduler.Advance(6)
+  // Ties go in the order the components were added.
+  expected := []int{2, 3, 2, 3, 2}
+  if len(order) != len(expected) {
+    log.Printf("Expecting %v, but got %v", expected, order)
+    t.FailNow()
+  }
+  for i := range expected {
+    if order[i] != expected[i] {
+      log.Printf("Expecting %v, but got %v", expected, order)
+      t.Fail()
+    }
+  }
+}
+
+// Passes writes on to the PPU, noting the dot the PPU was on for each one.
+type dotRecorder struct {
+  ppu *PPU
+  dots map[uint16]int
+}
+
+func (r *dotRecorder) Write(address uint16, value byte) {
+  r.dots[address] = r.ppu.dot
+  r.ppu.Write(address, value)
+}
+
+func TestMidInstructionCatchUp(t *testing.T) {
+  cpu := CPUNew()
+  ppu := PPUNew(nil)
+  scheduler := SchedulerNew(RegionNTSC)
+  scheduler.Add(RegionNTSC.PPUDivider, ppu.Step)
+  cpu.Connect(scheduler)
+  cpu.Map(0x2000, 0x3FFF, ppu)
+  recorder := &dotRecorder{ppu: ppu, dots: map[uint16]int{}}
+  cpu.MapWrite(0x2000, 0x3FFF, recorder)
+
+  cpu.SetInstructions([]byte{
+    0xA9, 0x1E, // LDA #$1E
+    0x8D, 0x01, 0x20, // STA $2001
+    0x8D, 0x06, 0x20, // STA $2006
+  })
+  cpu.pc = 0x8000
+  for i := 0; i < 3; i++ {
+    if err := cpu.Step(); err != nil {
+      log.Printf("Expecting the instructions to run (%v)", err)
+      t.FailNow()
+    }
+  }
+
+  // The write is on the fourth cycle of a STA, so three have gone by since the
+  // instruction started: 2 + 3 cycles for the first, and 6 + 3 for the other.
+  if recorder.dots[0x2001] != 15 {
+    log.Printf("Expecting the write to $2001 to land on dot 15, but it landed on %d", recorder.dots[0x2001])
+    t.Fail()
+  }
+  if recorder.dots[0x2006] != 27 {
+    log.Printf("Expecting the write to $2006 to land on dot 27, but it landed on %d", recorder.dots[0x2006])
+    t.Fail()
+  }
+  if ppu.mask != 0x1E || ppu.t >> 8 != 0x1E {
+    log.Printf("Expecting PPUMASK and PPUADDR to be written, but got %X and %X", ppu.mask, ppu.t)
+    t.Fail()
+  }
+  if ppu.dot != 30 || cpu.Cycles() != 10 {
+    log.Printf("Expecting 10 cycles and 30 dots in all, but got %d and %d", cpu.Cycles(), ppu.dot)
+    t.Fail()
+  }
+}
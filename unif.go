@@ -0,0 +1,171 @@
+package main
+
+import "bytes"
+import "errors"
+import "fmt"
+import "hash/crc32"
+import "strings"
+
+// The iNES mapper numbers of the boards known by their UNIF names, with any
+// "NES-", "HVC-", "UNL-" or similar prefix taken off.
+var unifBoards = map[string]uint16{
+  "NROM": 0, "NROM-128": 0, "NROM-256": 0, "RROM": 0, "RROM-128": 0,
+
+  "SAROM": 1, "SBROM": 1, "SCROM": 1, "SC1ROM": 1, "SEROM": 1, "SFROM": 1,
+  "SF1ROM": 1, "SGROM": 1, "SHROM": 1, "SH1ROM": 1, "SIROM": 1, "SJROM": 1,
+  "SKROM": 1, "SLROM": 1, "SL1ROM": 1, "SL2ROM": 1, "SL3ROM": 1,
+  "SLRROM": 1, "SMROM": 1, "SNROM": 1, "SOROM": 1, "SUROM": 1, "SXROM": 1,
+
+  "UNROM": 2, "UOROM": 2,
+
+  "CNROM": 3,
+
+  "TBROM": 4, "TEROM": 4, "TFROM": 4, "TGROM": 4, "TKROM": 4, "TLROM": 4,
+  "TL1ROM": 4, "TL2ROM": 4, "TNROM": 4, "TR1ROM": 4, "TSROM": 4, "TVROM": 4,
+  "B4": 4,
+
+  "EKROM": 5, "ELROM": 5, "ETROM": 5, "EWROM": 5,
+
+  "AMROM": 7, "ANROM": 7, "AN1ROM": 7, "AOROM": 7,
+
+  "PNROM": 9, "PEEOROM": 9,
+
+  "FJROM": 10, "FKROM": 10,
+
+  "CPROM": 13,
+
+  "GNROM": 66, "MHROM": 66,
+}
+
+// Works out the iNES mapper number for a UNIF board name.
+func unifBoardMapper(board string) (uint16, bool) {
+  board = strings.ToUpper(board)
+  if i := strings.Index(board, "-"); i == 3 {
+    board = board[4:]
+  }
+  mapper, ok := unifBoards[board]
+  return mapper, ok
+}
+
+// Parses a UNIF ROM image.
+//
+// The board name in the MAPR chunk is translated into an iNES mapper number,
+// so that UNIF images end up going through the same mappers as iNES ones.
+// Boards whose mapper isn't implemented yet are rejected here, rather than
+// when the cartridge is plugged in.
+func parseUNIF(rom []byte) (*Cartridge, error) {
+  if len(rom) < 32 {
+    return nil, errors.New("UNIF header is truncated")
+  }
+
+  var prg, chr [16][]byte
+  var prgCRC, chrCRC [16]*uint32
+  board := ""
+  cart := &Cartridge{
+    mirroring: MIRROR_HORIZONTAL,
+    prgRAMSize: 0x2000,
+  }
+
+  offset := 32
+  for offset < len(rom) {
+    if offset + 8 > len(rom) {
+      return nil, errors.New("UNIF chunk header is truncated")
+    }
+    id := string(rom[offset:offset+4])
+    length := int(GetUint32LEAt(rom, offset + 4))
+    offset += 8
+    if length < 0 || offset + length > len(rom) {
+      return nil, fmt.Errorf("UNIF chunk %s is truncated", id)
+    }
+    data := rom[offset:offset+length]
+    offset += length
+
+    switch {
+    case id == "MAPR":
+      if i := bytes.IndexByte(data, 0); i >= 0 {
+        data = data[:i]
+      }
+      board = string(data)
+    case id == "MIRR" && length > 0:
+      switch data[0] {
+      case 0: cart.mirroring = MIRROR_HORIZONTAL
+      case 1: cart.mirroring = MIRROR_VERTICAL
+      case 2: cart.mirroring = MIRROR_SINGLE_LOWER
+      case 3: cart.mirroring = MIRROR_SINGLE_UPPER
+      case 4: cart.mirroring = MIRROR_FOUR_SCREEN
+      // 5 means the mapper controls it, which it will do anyway.
+      }
+    case id == "TVCI" && length > 0:
+      switch data[0] {
+      case 0: cart.timing = TIMING_NTSC
+      case 1: cart.timing = TIMING_PAL
+      case 2: cart.timing = TIMING_MULTIPLE
+      }
+    case id == "BATR":
+      cart.battery = true
+    case strings.HasPrefix(id, "PRG") || strings.HasPrefix(id, "CHR") ||
+         strings.HasPrefix(id, "PCK") || strings.HasPrefix(id, "CCK"):
+      index, ok := unifChunkIndex(id[3])
+      if !ok {
+        break
+      }
+      switch id[:3] {
+      case "PRG": prg[index] = data
+      case "CHR": chr[index] = data
+      case "PCK", "CCK":
+        if length < 4 {
+          return nil, fmt.Errorf("UNIF chunk %s is truncated", id)
+        }
+        crc := GetUint32LEAt(data, 0)
+        if id[:3] == "PCK" {
+          prgCRC[index] = &crc
+        } else {
+          chrCRC[index] = &crc
+        }
+      }
+    }
+  }
+
+  if board == "" {
+    return nil, errors.New("UNIF image has no MAPR chunk")
+  }
+  mapper, ok := unifBoardMapper(board)
+  if !ok {
+    return nil, fmt.Errorf("UNIF board %s not supported", board)
+  }
+  if err := checkMapper(mapper); err != nil {
+    return nil, err
+  }
+  cart.mapper = mapper
+
+  // The PRG and CHR chunks are laid out one after the other, in order.
+  for i := 0; i < 16; i++ {
+    if prgCRC[i] != nil && prg[i] != nil && crc32.ChecksumIEEE(prg[i]) != *prgCRC[i] {
+      return nil, fmt.Errorf("UNIF chunk PRG%X checksum mismatch", i)
+    }
+    if chrCRC[i] != nil && chr[i] != nil && crc32.ChecksumIEEE(chr[i]) != *chrCRC[i] {
+      return nil, fmt.Errorf("UNIF chunk CHR%X checksum mismatch", i)
+    }
+    cart.prg = append(cart.prg, prg[i]...)
+    cart.chr = append(cart.chr, chr[i]...)
+  }
+
+  if len(cart.prg) == 0 {
+    return nil, errors.New("ROM has no PRG data")
+  }
+  if len(cart.chr) == 0 {
+    cart.chrRAM = true
+    cart.chr = make([]byte, 0x2000)
+  }
+
+  return cart, nil
+}
+
+// Gets the index of a PRGn or CHRn chunk from the hex digit at the end of its ID.
+func unifChunkIndex(digit byte) (int, bool) {
+  switch {
+  case digit >= '0' && digit <= '9': return int(digit - '0'), true
+  case digit >= 'A' && digit <= 'F': return int(digit - 'A') + 10, true
+  }
+  return 0, false
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+import "log"
+import "hash/crc32"
+
+func appendUNIFChunk(rom []byte, id string, data []byte) []byte {
+  length := len(data)
+  rom = append(rom, []byte(id)...)
+  rom = append(rom, byte(length), byte(length >> 8), byte(length >> 16), byte(length >> 24))
+  return append(rom, data...)
+}
+
+func buildUNIF(board string) []byte {
+  rom := make([]byte, 32)
+  copy(rom, []byte("UNIF"))
+  rom[4] = 7
+  prg0 := make([]byte, PRG_BANK_SIZE)
+  prg1 := make([]byte, PRG_BANK_SIZE)
+  prg1[0] = 0xEA
+  crc := crc32.ChecksumIEEE(prg1)
+  rom = appendUNIFChunk(rom, "MAPR", []byte(board + "\x00"))
+  rom = appendUNIFChunk(rom, "PRG1", prg1)
+  rom = appendUNIFChunk(rom, "PRG0", prg0)
+  rom = appendUNIFChunk(rom, "PCK1", []byte{byte(crc), byte(crc >> 8), byte(crc >> 16), byte(crc >> 24)})
+  rom = appendUNIFChunk(rom, "MIRR", []byte{1})
+  rom = appendUNIFChunk(rom, "BATR", []byte{1})
+  return rom
+}
+
+func TestLoadUNIF(t *testing.T) {
+  cart, err := LoadCartridge(buildUNIF("NES-NROM-256"))
+  if err != nil {
+    log.Printf("Expecting no error, but got %s", err)
+    t.FailNow()
+  }
+  if cart.MapperNumber() != 0 {
+    log.Printf("Expecting mapper 0, but got %d", cart.MapperNumber())
+    t.Fail()
+  }
+  if len(cart.PRG()) != 2 * PRG_BANK_SIZE || cart.PRG()[PRG_BANK_SIZE] != 0xEA {
+    log.Printf("Expecting PRG1 to be laid out after PRG0")
+    t.Fail()
+  }
+  if !cart.chrRAM || len(cart.CHR()) != CHR_BANK_SIZE {
+    log.Printf("Expecting 8 KiB of CHR RAM")
+    t.Fail()
+  }
+  if cart.Mirroring() != MIRROR_VERTICAL || !cart.Battery() {
+    log.Printf("Expecting vertical mirroring and a battery")
+    t.Fail()
+  }
+
+  // SXROM is mapper 1, which isn't implemented.
+  _, err = LoadCartridge(buildUNIF("UNL-SXROM"))
+  if err == nil || err.Error() != "Mapper 1 not supported" {
+    log.Printf("Expecting SXROM to be rejected as mapper 1 (%v)", err)
+    t.Fail()
+  }
+  if mapper, ok := unifBoardMapper("UNL-SXROM"); !ok || mapper != 1 {
+    log.Printf("Expecting SXROM to be mapper 1")
+    t.Fail()
+  }
+
+  if _, err := LoadCartridge(buildUNIF("NES-NOSUCHBOARD")); err == nil {
+    log.Printf("Expecting an unknown board to fail")
+    t.Fail()
+  }
+
+  rom := buildUNIF("NES-NROM-256")
+  rom[32 + 8 + 13 + 8] ^= 0xFF
+  if _, err := LoadCartridge(rom); err == nil {
+    log.Printf("Expecting a PRG checksum mismatch")
+    t.Fail()
+  }
+}
@@ -0,0 +1,63 @@
+package main
+
+// Represents the Arkanoid Vaus paddle, either the NES one that goes in port 2,
+// or the Famicom one that goes in the expansion port.
+//
+// The knob turns a potentiometer whose position gets latched on the strobe
+// and shifted out inverted, a bit at a time starting from the top one.
+type Vaus struct {
+  famicom bool
+  position byte
+  button bool
+  strobe bool
+  shift byte
+}
+
+// Initializes a new Vaus paddle, for the Famicom's expansion port or for the
+// NES's port 2. It starts off in the middle of its range.
+func VausNew(famicom bool) *Vaus {
+  return &Vaus{famicom: famicom, position: 0xAA}
+}
+
+// Sets where the knob is turned to. On hardware, it only goes from about $62
+// to about $F2.
+func (v *Vaus) SetPosition(position byte) {
+  v.position = position
+}
+
+// Presses or releases the button.
+func (v *Vaus) SetButton(pressed bool) {
+  v.button = pressed
+}
+
+// Takes the strobe.
+func (v *Vaus) Write(value byte) {
+  v.strobe = value & 1 != 0
+  if v.strobe {
+    v.shift = ^v.position
+  }
+}
+
+// Gets the button and the next bit of the position. The NES paddle puts them
+// on bits 3 and 4 of $4017, and the Famicom one on bit 1 of $4016 and $4017.
+func (v *Vaus) Read(address uint16) byte {
+  var button byte
+  if v.button {
+    button = 1
+  }
+  if v.famicom && address == 0x4016 {
+    return button << 1
+  }
+
+  if v.strobe {
+    v.shift = ^v.position
+  }
+  bit := v.shift >> 7
+  if !v.strobe {
+    v.shift <<= 1
+  }
+  if v.famicom {
+    return bit << 1
+  }
+  return button << 3 | bit << 4
+}
@@ -0,0 +1,177 @@
+package main
+
+import "bufio"
+import "encoding/binary"
+import "os"
+import "path/filepath"
+import "strings"
+
+// The size of a WAV file's header, up to the start of the samples.
+const WAV_HEADER_SIZE = 44
+
+// Writes 16-bit mono samples out to a .wav file.
+type WAVWriter struct {
+  file *os.File
+  writer *bufio.Writer
+  sampleRate int
+  samples int
+}
+
+// Creates a .wav file at the given sample rate to write samples to.
+func WAVWriterNew(filename string, sampleRate int) (*WAVWriter, error) {
+  file, err := os.Create(filename)
+  if err != nil { return nil, err }
+  w := &WAVWriter{file: file, writer: bufio.NewWriter(file), sampleRate: sampleRate}
+  // The sizes in the header get filled in once the samples are all written.
+  if _, err := w.writer.Write(w.header()); err != nil {
+    file.Close()
+    return nil, err
+  }
+  return w, nil
+}
+
+// Builds the file's header, for the samples written so far.
+func (w *WAVWriter) header() []byte {
+  dataSize := uint32(w.samples * 2)
+  header := make([]byte, WAV_HEADER_SIZE)
+  copy(header, "RIFF")
+  binary.LittleEndian.PutUint32(header[4:], WAV_HEADER_SIZE - 8 + dataSize)
+  copy(header[8:], "WAVEfmt ")
+  binary.LittleEndian.PutUint32(header[16:], 16)
+  // PCM, in one channel.
+  binary.LittleEndian.PutUint16(header[20:], 1)
+  binary.LittleEndian.PutUint16(header[22:], 1)
+  binary.LittleEndian.PutUint32(header[24:], uint32(w.sampleRate))
+  binary.LittleEndian.PutUint32(header[28:], uint32(w.sampleRate * 2))
+  binary.LittleEndian.PutUint16(header[32:], 2)
+  binary.LittleEndian.PutUint16(header[34:], 16)
+  copy(header[36:], "data")
+  binary.LittleEndian.PutUint32(header[40:], dataSize)
+  return header
+}
+
+// Writes samples, from -1 to 1, to the file.
+func (w *WAVWriter) Write(samples []float32) error {
+  var buffer [2]byte
+  for _, sample := range samples {
+    if sample > 1 { sample = 1 }
+    if sample < -1 { sample = -1 }
+    binary.LittleEndian.PutUint16(buffer[:], uint16(int16(sample * 32767)))
+    if _, err := w.writer.Write(buffer[:]); err != nil {
+      return err
+    }
+  }
+  w.samples += len(samples)
+  return nil
+}
+
+// Fills in the sizes in the header, and closes the file.
+func (w *WAVWriter) Close() error {
+  err := w.writer.Flush()
+  if err == nil {
+    _, err = w.file.WriteAt(w.header(), 0)
+  }
+  if closeErr := w.file.Close(); err == nil {
+    err = closeErr
+  }
+  return err
+}
+
+// The stems a recording can be split into, one for each of the APU's channels
+// plus expansion audio, in the order they're kept in.
+var RecorderStems = []string{"pulse1", "pulse2", "triangle", "noise", "dmc", "expansion"}
+
+// Records the APU's output to a .wav file while the emulator runs, optionally
+// along with a file for each channel on its own.
+//
+// It's clocked alongside the CPU, like the mixer, and needs closing once the
+// recording's done.
+type Recorder struct {
+  apu *APU
+  mix *resampler
+  writer *WAVWriter
+  stems []*resampler
+  stemWriters []*WAVWriter
+  // The first error writing any of the files ran into, which stops the
+  // recording.
+  err error
+}
+
+// Starts recording the APU's output to the given file, at the given sample
+// rate. With stems, each channel also gets recorded to a file named after the
+// channel, next to it: recording "song.wav" makes "song-pulse1.wav" and so on.
+func RecorderNew(apu *APU, filename string, sampleRate int, stems bool) (*Recorder, error) {
+  clockRate := apu.region.CPUClockRate()
+  writer, err := WAVWriterNew(filename, sampleRate)
+  if err != nil { return nil, err }
+  r := &Recorder{apu: apu, mix: resamplerNew(clockRate, sampleRate), writer: writer}
+
+  if stems {
+    base := strings.TrimSuffix(filename, filepath.Ext(filename))
+    for _, stem := range RecorderStems {
+      writer, err := WAVWriterNew(base + "-" + stem + ".wav", sampleRate)
+      if err != nil {
+        r.Close()
+        return nil, err
+      }
+      r.stems = append(r.stems, resamplerNew(clockRate, sampleRate))
+      r.stemWriters = append(r.stemWriters, writer)
+    }
+  }
+  return r, nil
+}
+
+// Gets the output of each of the APU's channels on their own, in the order of
+// RecorderStems, on the same scale as the mixed output.
+func (a *APU) channelOutputs() [6]float64 {
+  var expansion float64
+  if a.expansion != nil {
+    expansion = a.expansion.Output()
+  }
+  return [6]float64{
+    pulseTable[a.pulse1.output()],
+    pulseTable[a.pulse2.output()],
+    tndTable[3 * int(a.triangle.output())],
+    tndTable[2 * int(a.noise.output())],
+    tndTable[a.dmc.output()],
+    expansion,
+  }
+}
+
+// Takes in the APU's output for a CPU cycle, writing out any samples that are
+// ready.
+func (r *Recorder) Clock() {
+  if r.err != nil {
+    return
+  }
+  r.mix.add(r.apu.Output())
+  if len(r.mix.samples) > 0 {
+    r.err = r.writer.Write(r.mix.take())
+  }
+
+  if r.stems == nil {
+    return
+  }
+  outputs := r.apu.channelOutputs()
+  for i, stem := range r.stems {
+    stem.add(outputs[i])
+    if len(stem.samples) > 0 && r.err == nil {
+      r.err = r.stemWriters[i].Write(stem.take())
+    }
+  }
+}
+
+// Finishes the recording, closing all of its files. It gives back the first
+// error the recording ran into, if any.
+func (r *Recorder) Close() error {
+  err := r.err
+  if closeErr := r.writer.Close(); err == nil {
+    err = closeErr
+  }
+  for _, writer := range r.stemWriters {
+    if closeErr := writer.Close(); err == nil {
+      err = closeErr
+    }
+  }
+  return err
+}
@@ -0,0 +1,99 @@
+package main
+
+const (
+  // Bits of the Zapper's reads.
+  ZAPPER_LIGHT byte = 0x08
+  ZAPPER_TRIGGER byte = 0x10
+
+  // How many scanlines a spot on the screen keeps the photodiode lit after the
+  // beam has drawn it.
+  ZAPPER_LIGHT_SCANLINES = 20
+  // How far around the spot it's aimed at the Zapper can see, in pixels.
+  ZAPPER_RADIUS = 2
+  // How bright a pixel has to be, out of 255, for the Zapper to see it.
+  ZAPPER_BRIGHTNESS = 0x80
+)
+
+// Represents the Zapper light gun, which usually goes in port 2.
+//
+// Its photodiode only picks up light from pixels the beam has drawn in the
+// last few scanlines, so it looks at the PPU's framebuffer around where it's
+// aimed, relative to where the beam is at the time of the read.
+type Zapper struct {
+  ppu *PPU
+  x, y int
+  trigger bool
+}
+
+// Initializes a new Zapper, looking at what the given PPU draws. It starts
+// off aimed off the screen.
+func ZapperNew(ppu *PPU) *Zapper {
+  return &Zapper{ppu: ppu, x: -1, y: -1}
+}
+
+// Aims the Zapper at a pixel on the screen. Anywhere off the screen sees no
+// light at all.
+func (z *Zapper) Aim(x, y int) {
+  z.x = x
+  z.y = y
+}
+
+// Pulls or releases the trigger.
+func (z *Zapper) SetTrigger(pulled bool) {
+  z.trigger = pulled
+}
+
+// The Zapper doesn't use the strobe.
+func (z *Zapper) Write(value byte) {}
+
+// Gets whether the trigger is pulled on bit 4, and on bit 3, whether light
+// is being seen, which reads as 0 when it is.
+func (z *Zapper) Read(address uint16) byte {
+  var value byte
+  if z.trigger {
+    value |= ZAPPER_TRIGGER
+  }
+  if !z.sensesLight() {
+    value |= ZAPPER_LIGHT
+  }
+  return value
+}
+
+// Gets whether any bright pixel around where the Zapper is aimed has been
+// drawn recently enough to still be lighting up the photodiode.
+func (z *Zapper) sensesLight() bool {
+  if z.x < 0 || z.x >= FRAME_WIDTH || z.y < 0 || z.y >= FRAME_HEIGHT {
+    return false
+  }
+  for y := z.y - ZAPPER_RADIUS; y <= z.y + ZAPPER_RADIUS; y++ {
+    for x := z.x - ZAPPER_RADIUS; x <= z.x + ZAPPER_RADIUS; x++ {
+      if x < 0 || x >= FRAME_WIDTH || y < 0 || y >= FRAME_HEIGHT {
+        continue
+      }
+      if z.recentlyDrawn(x, y) && z.bright(x, y) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// Gets whether the beam drew a pixel in the last ZAPPER_LIGHT_SCANLINES
+// scanlines, going back into the last frame if need be.
+func (z *Zapper) recentlyDrawn(x, y int) bool {
+  now := z.ppu.scanline * DOTS_PER_SCANLINE + z.ppu.dot
+  // The pixel gets drawn on dot x + 1.
+  drawn := y * DOTS_PER_SCANLINE + x + 1
+  elapsed := now - drawn
+  if elapsed <= 0 {
+    elapsed += z.ppu.region.Scanlines * DOTS_PER_SCANLINE
+  }
+  return elapsed < ZAPPER_LIGHT_SCANLINES * DOTS_PER_SCANLINE
+}
+
+// Gets whether a pixel in the framebuffer is bright enough to be seen.
+func (z *Zapper) bright(x, y int) bool {
+  c := DefaultPalette.Color(z.ppu.framebuffer[y * FRAME_WIDTH + x])
+  luminance := (299 * int(c.R) + 587 * int(c.G) + 114 * int(c.B)) / 1000
+  return luminance >= ZAPPER_BRIGHTNESS
+}